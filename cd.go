@@ -21,9 +21,13 @@ import (
 )
 
 var (
-	ctx    context.Context
-	cancel context.CancelFunc
-	repoURLs  []string
+	ctx      context.Context
+	cancel   context.CancelFunc
+	repoURLs []string
+	// each changed repo is deployed separately, so failure of one does not affect others. Used by `cdurl` which urls are independent
+	deployIndependently bool
+	// signaled by trackers which are notified about changes, so next iteration starts without waiting for `--timeout`
+	wakeUpCh = make(chan struct{}, 1)
 	// used in tests
 	afterIteration func()              = func() {}
 	onError        func(r interface{}) = func(r interface{}) {}
@@ -93,12 +97,16 @@ func seed(ctx context.Context, wg *sync.WaitGroup) {
 		}
 	}
 
+	restoreDeployment()
+
 	for {
 		iteration()
 		afterIteration()
 		// TODO: clean WD after iteration
 		select {
 		case <-time.After(timeoutDur):
+		case <-wakeUpCh:
+			gc.Verbose("seeder", "Woken up")
 		case <-ctx.Done():
 			gc.Verbose("seeder", "Done")
 			return
@@ -106,6 +114,47 @@ func seed(ctx context.Context, wg *sync.WaitGroup) {
 	}
 }
 
+// restoreDeployment starts repos deployed before cder restart. Everything will be redeployed on failure
+func restoreDeployment() {
+	deployedRepos := watcher.Deployed(repoURLs)
+	if len(deployedRepos) == 0 {
+		return
+	}
+	if !deployIndependently {
+		startDeployed(deployedRepos)
+		return
+	}
+	for _, deployedRepo := range deployedRepos {
+		startDeployed([]string{deployedRepo})
+	}
+}
+
+func startDeployed(deployedRepos []string) {
+	defer func() {
+		if r := recover(); r != nil {
+			gc.Error("restoreDeployment: Recovered: ", r)
+			if deployIndependently {
+				repo, _ := watcher.Revision(deployedRepos[0])
+				gc.Info("Stored state is reset, will be redeployed:", repo)
+				delete(state.URLs, repo)
+				return
+			}
+			gc.Info("Stored state is reset, everything will be redeployed")
+			state.reset()
+		}
+	}()
+	gc.Info("Already deployed, starting", deployedRepos)
+	deployer.Start(deployedRepos)
+}
+
+// wakeUp starts next iteration immediately
+func wakeUp() {
+	select {
+	case wakeUpCh <- struct{}{}:
+	default:
+	}
+}
+
 func iteration() {
 	defer func() {
 		if r := recover(); r != nil {
@@ -118,12 +167,26 @@ func iteration() {
 	changedRepos := watcher.Watch(repoURLs)
 	if len(changedRepos) > 0 {
 		watcher.Clean(changedRepos) // clean before build
-		for _, changedRepo := range changedRepos {
-			deployer.Deploy(changedRepo)
+		if deployIndependently {
+			for _, changedRepo := range changedRepos {
+				deployRecovered([]string{changedRepo})
+			}
+		} else {
+			deploy(changedRepos)
 		}
-		deployer.DeployAll(changedRepos)
 		watcher.Clean(changedRepos) // clean after build. May be not reached in case of panic on Deploy*()
 	} else {
 		gc.Verbose("*** Nothing changed")
 	}
 }
+
+// deployRecovered deploys `changedRepos`, failure is logged and does not stop the iteration
+func deployRecovered(changedRepos []string) {
+	defer func() {
+		if r := recover(); r != nil {
+			gc.Error("deploy: Recovered: ", changedRepos, r)
+			onError(r)
+		}
+	}()
+	deploy(changedRepos)
+}
@@ -19,7 +19,7 @@ import (
 	"path"
 	"path/filepath"
 	"testing"
- 
+
 	"github.com/stretchr/testify/require"
 	gc "github.com/untillpro/gochips"
 )
@@ -30,10 +30,10 @@ var (
 #!/bin/bash
 case $1 in
 	"deploy")
-		rm -r ../../../deploy     
-		mkdir ../../../deploy
-		cp test%d.txt ../../../deploy/testDeployed%d.txt
-		echo $2 >> ../../../deploy/add.txt
+		rm -r ../../../../../deploy     
+		mkdir ../../../../../deploy
+		cp test%d.txt ../../../../../deploy/testDeployed%d.txt
+		echo $2 >> ../../../../../deploy/add.txt
 		;;
 	"stop")
 		echo "deployer.stop"
@@ -150,7 +150,7 @@ func TestCderURLBasic(t *testing.T) {
 		getArtifactsFolder()
 		addFileBytes, err := ioutil.ReadFile(path.Join(workingDir, "deploy/add.txt"))
 		require.Nil(t, err)
-		require.Equal(t, path.Join(getArtifactHomePath(tsMain.URL), "work-dir")+"\n", string(addFileBytes))
+		require.Equal(t, path.Join(getArtifactHomePath(tsMain.URL), "current")+"\n", string(addFileBytes))
 		require.Equal(t, expectedBytes, actualBytes)
 		require.DirExists(t, path.Join(testWD, "init"))
 		if counter > 3 {
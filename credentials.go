@@ -0,0 +1,207 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	gc "github.com/untillpro/gochips"
+)
+
+var (
+	gitCredentials []string
+	// normalizeRepoURL()-like prefix (host or host/owner) -> credential
+	credentials = map[string]*credential{}
+	// values to be hidden in the output
+	secrets           []string
+	urlUserInfoRegexp = regexp.MustCompile(`(://[^/:@\s]+:)[^/@\s]+@`)
+	// SSH keys from environment variables written to files, removed on exit
+	tempKeyFiles []string
+	// known_hosts file SSH hosts are verified against, see `--known-hosts`
+	knownHostsFile string
+)
+
+const gitCredentialHelper = `!f() { test "$1" = get && printf 'username=%%s\npassword=%%s\n' "$CDER_GIT_USERNAME_%[1]d" "$CDER_GIT_PASSWORD_%[1]d"; }; f`
+
+// credential to access private repos and artifacts. Either token or SSH key
+type credential struct {
+	prefix string
+	// empty -> token is sent as bearer to http hosts
+	username   string
+	token      string
+	sshKeyPath string
+}
+
+func init() {
+	output := gc.Output
+	gc.Output = func(funcName, s string) {
+		output(funcName, redactSecrets(s))
+	}
+}
+
+// redactSecrets hides tokens and passwords of urls
+func redactSecrets(s string) string {
+	for _, secret := range secrets {
+		s = strings.Replace(s, secret, "***", -1)
+	}
+	// registry tokens are refreshed, so only the current ones are kept
+	for _, token := range registryTokens {
+		s = strings.Replace(s, token, "***", -1)
+	}
+	return urlUserInfoRegexp.ReplaceAllString(s, "${1}***@")
+}
+
+// loadCredentials parses `--git-credential <host>[/<owner>]=<file>|env:<VAR>` items.
+// Content is a token, `<username>:<token>` or an SSH private key
+func loadCredentials() error {
+	credentials = map[string]*credential{}
+	for _, item := range gitCredentials {
+		pos := strings.Index(item, "=")
+		if pos < 0 {
+			return fmt.Errorf("--git-credential must be <host>=<file>|env:<VAR>: %s", item)
+		}
+		cred := &credential{prefix: strings.ToLower(strings.Trim(item[:pos], "/"))}
+		source := item[pos+1:]
+		var content string
+		if strings.HasPrefix(source, "env:") {
+			value, ok := os.LookupEnv(strings.TrimPrefix(source, "env:"))
+			if !ok {
+				return fmt.Errorf("--git-credential %s: environment variable is not set", item[:pos])
+			}
+			content = value
+		} else {
+			contentBytes, err := ioutil.ReadFile(source)
+			if err != nil {
+				return fmt.Errorf("--git-credential %s: %w", item[:pos], err)
+			}
+			content = string(contentBytes)
+		}
+		if strings.Contains(content, "PRIVATE KEY-----") {
+			cred.sshKeyPath = source
+			if strings.HasPrefix(source, "env:") {
+				// ssh needs a file
+				keyFile, err := ioutil.TempFile("", "cder-key")
+				if err != nil {
+					return err
+				}
+				defer keyFile.Close()
+				tempKeyFiles = append(tempKeyFiles, keyFile.Name())
+				if _, err := keyFile.WriteString(content); err != nil {
+					return err
+				}
+				cred.sshKeyPath = keyFile.Name()
+			}
+		} else {
+			cred.token = strings.TrimSpace(content)
+			if pos := strings.Index(cred.token, ":"); pos >= 0 {
+				cred.username, cred.token = cred.token[:pos], cred.token[pos+1:]
+			}
+			if len(cred.token) == 0 {
+				return fmt.Errorf("--git-credential %s: token is empty", item[:pos])
+			}
+			secrets = append(secrets, cred.token)
+		}
+		credentials[cred.prefix] = cred
+		gc.Verbose("loadCredentials", "Credential loaded for "+cred.prefix)
+	}
+	return nil
+}
+
+// removeTempKeyFiles removes SSH keys written by loadCredentials
+func removeTempKeyFiles() {
+	for _, file := range tempKeyFiles {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			gc.Error("removeTempKeyFiles:", err)
+		}
+	}
+	tempKeyFiles = nil
+}
+
+// findCredential returns the credential with the longest prefix matching `rawURL`
+func findCredential(rawURL string) *credential {
+	key := normalizeRepoURL(rawURL)
+	var res *credential
+	for prefix, cred := range credentials {
+		if key == prefix || strings.HasPrefix(key, prefix+"/") {
+			if res == nil || len(prefix) > len(res.prefix) {
+				res = cred
+			}
+		}
+	}
+	return res
+}
+
+// gitCommand returns git command which accesses `repoURL` using configured credentials.
+// Tokens are passed via environment to the credential helper, so they never get into command line or output
+func gitCommand(repoURL string, args ...string) *gc.PipedExec {
+	var env []string
+	var configArgs []string
+	hostCreds := map[string]*credential{}
+	for prefix, cred := range credentials {
+		if !strings.Contains(prefix, "/") && len(cred.token) > 0 {
+			hostCreds[prefix] = cred
+		}
+	}
+	repoCred := findCredential(repoURL)
+	if repoCred != nil && len(repoCred.token) > 0 {
+		host, _ := splitRepoURL(repoURL)
+		hostCreds[strings.ToLower(host)] = repoCred
+	}
+	idx := 0
+	for host, cred := range hostCreds {
+		username := cred.username
+		if len(username) == 0 {
+			username = "cder"
+		}
+		for _, scheme := range []string{"https", "http"} {
+			configArgs = append(configArgs,
+				"-c", "credential."+scheme+"://"+host+".helper=",
+				"-c", "credential."+scheme+"://"+host+".helper="+fmt.Sprintf(gitCredentialHelper, idx))
+		}
+		env = append(env, "CDER_GIT_USERNAME_"+strconv.Itoa(idx)+"="+username, "CDER_GIT_PASSWORD_"+strconv.Itoa(idx)+"="+cred.token)
+		idx++
+	}
+	if repoCred != nil && len(repoCred.sshKeyPath) > 0 {
+		sshArgs := []string{"ssh", "-i", repoCred.sshKeyPath, "-o", "IdentitiesOnly=yes"}
+		if len(knownHostsFile) > 0 {
+			sshArgs = append(sshArgs, "-o", "UserKnownHostsFile="+knownHostsFile, "-o", "StrictHostKeyChecking=yes")
+		}
+		// GIT_SSH_COMMAND is executed by shell
+		env = append(env, "GIT_SSH_COMMAND="+shellQuoteArgs(sshArgs))
+	}
+	pe := new(gc.PipedExec).Command("git", append(configArgs, args...)...)
+	if len(env) > 0 {
+		cmd := pe.GetCmd(0)
+		cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), env...)
+	}
+	return pe
+}
+
+// setHTTPCredential authorizes the request using token configured for its url.
+// Bearer if the username is not specified, basic otherwise
+func setHTTPCredential(req *http.Request) {
+	setRequestCredential(req, findCredential(req.URL.String()))
+}
+
+func setRequestCredential(req *http.Request, cred *credential) {
+	if cred == nil || len(cred.token) == 0 {
+		return
+	}
+	if len(cred.username) == 0 {
+		req.Header.Set("Authorization", "Bearer "+cred.token)
+	} else {
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(cred.username+":"+cred.token)))
+	}
+}
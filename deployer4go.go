@@ -42,14 +42,14 @@ func (d *deployer4go) DeployAll(repos []string) {
 	d.replaceGoMod()
 	gc.Info("itdeployer4go.DeployAll:", "Main repo will be rebuilt")
 	gc.Doing("go build")
-	params := []string {"build", "-o", binaryName}
+	params := []string{"build", "-o", binaryName}
 	if len(buildPath) > 0 {
 		params = append(params, buildPath)
 	}
 	err := new(gc.PipedExec).
 		Command("go", params...).
 		WorkingDir(d.wd).
-		Run(gc.VerboseWriters())
+		Run(deployWriters(gc.VerboseWriters()))
 	gc.PanicIfError(err)
 	gc.Info("deployer4go.DeployAll:", "Build finished")
 
@@ -63,16 +63,35 @@ func (d *deployer4go) DeployAll(repos []string) {
 		WorkingDir(d.wd).
 		Run(os.Stdout, os.Stderr)
 	gc.PanicIfError(err)
+	state.Binary = fileToExec
+	// `Cder-Args` are kept, so the binary is started with the same args after cder restart
+	state.BinaryArgs = append(append([]string{}, d.args...), commitDeployArgs...)
 
 	// Run executable
-	gc.Doing("deployer4go.DeployAll: Running " + fileToExec)
+	d.run(fileToExec, state.BinaryArgs)
+}
+
+func (d *deployer4go) Start(repos []string) {
+	if len(state.Binary) == 0 || !fileExists(state.Binary) {
+		panic("deployer4go.Start: built binary not found: " + state.Binary)
+	}
+	args := d.args
+	if state.BinaryArgs != nil {
+		args = state.BinaryArgs
+	}
+	d.stopCmd()
+	d.run(state.Binary, args)
+}
+
+func (d *deployer4go) run(fileToExec string, args []string) {
+	gc.Doing("deployer4go: Running " + fileToExec)
 	pe := new(gc.PipedExec)
-	err = pe.Command(fileToExec, d.args...).
+	err := pe.Command(fileToExec, args...).
 		WorkingDir(d.wd).
 		Start(os.Stdout, os.Stderr)
 	gc.PanicIfError(err)
 	d.cmd = pe.GetCmd(0)
-	gc.Info("deployer4go.DeployAll:", "Process started!")
+	gc.Info("deployer4go:", "Process started!")
 }
 
 func withTimeout(f func()) bool {
@@ -133,12 +152,15 @@ func (d *deployer4go) replaceGoMod() {
 	goModPathContent := string(goModPathContentBytes)
 
 	for repFrom, repTo := range replacements {
-		_, toFolder := getAbsRepoFolders(repTo)
+		toPath, _ := getAbsRepoFolders(repTo)
+		toRelPath, err := filepath.Rel(d.wd, toPath)
+		gc.PanicIfError(err)
 
-		u, err := url.Parse(repFrom)
+		fromURL, _ := parseRepoURL(repFrom)
+		u, err := url.Parse(fromURL)
 		gc.PanicIfError(err)
 
-		replace := "replace " + u.Hostname() + u.RequestURI() + " => " + path.Join("..", toFolder)
+		replace := "replace " + u.Hostname() + u.RequestURI() + " => " + filepath.ToSlash(toRelPath)
 		gc.Info("deployer4go.replaceGoMod", replace)
 		goModPathContent = goModPathContent + replace + "\n"
 	}
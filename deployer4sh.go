@@ -16,29 +16,62 @@ import (
 
 type deployer4sh struct {
 	wd string
+	// watched by watcherURL, env and args of the deployed manifest are taken from its stored state, see artifactManifest
+	repo string
+}
+
+// newDeployer4sh returns deployer of artifacts watched at `repo` by watcherURL
+func newDeployer4sh(repo string) *deployer4sh {
+	return &deployer4sh{
+		wd:   getCurrentVersionPath(repo),
+		repo: repo,
+	}
 }
 
 func (d *deployer4sh) Deploy(repo string) {
-	d.execCommand("deploy", []string{repo}, true)
+	d.execCommand("deploy", []string{repo}, commitDeployArgs, true)
 }
 
 func (d *deployer4sh) DeployAll(repos []string) {
-	d.execCommand("deploy-all", repos, true)
+	d.execCommand("deploy-all", repos, commitDeployArgs, true)
+	// `Cder-Args` are kept, so deploy.sh is started with the same args after cder restart
+	state.DeployerArgs = append([]string{}, commitDeployArgs...)
+}
+
+func (d *deployer4sh) Start(repos []string) {
+	d.execCommand("deploy-all", repos, state.DeployerArgs, true)
 }
 
 func (d *deployer4sh) Stop() {
-	d.execCommand("stop", nil, false)
+	d.execCommand("stop", nil, nil, false)
 }
 
-func (d *deployer4sh) execCommand(command string, commandArgs []string, panicOnError bool) (err error) {
+// execCommand runs `deploy.sh <command> <commandArgs>`. Manifest args and `commitArgs` are passed after `--` and in CDER_ARGS
+func (d *deployer4sh) execCommand(command string, commandArgs []string, commitArgs []string, panicOnError bool) (err error) {
 	var args []string
 	args = append(args, deployerEnv...)
+	var manifestArgs []string
+	if stored, ok := state.URLs[d.repo]; ok && len(d.repo) > 0 {
+		args = append(args, stored.Env...)
+		manifestArgs = stored.Args
+	}
+	cderArgs := append(append([]string{}, manifestArgs...), commitArgs...)
+	if command == "stop" {
+		cderArgs = nil
+	}
+	if len(cderArgs) > 0 {
+		args = append(args, "CDER_ARGS="+shellQuoteArgs(cderArgs))
+	}
 	args = append(args, path.Join(d.wd, "deploy.sh"), command)
 	args = append(args, commandArgs...)
+	if len(cderArgs) > 0 {
+		args = append(args, "--")
+		args = append(args, cderArgs...)
+	}
 	err = new(gc.PipedExec).
 		Command("env", args...).
 		WorkingDir(d.wd).
-		Run(os.Stdout, os.Stderr)
+		Run(deployWriters(os.Stdout, os.Stderr))
 	if panicOnError {
 		gc.PanicIfError(err)
 	}
@@ -0,0 +1,49 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeployer4shArgs(t *testing.T) {
+	wd, err := ioutil.TempDir("", "cder-deployer4sh")
+	require.Nil(t, err)
+	defer os.RemoveAll(wd)
+	defer func(s *cderState, args []string) { state, commitDeployArgs = s, args }(state, commitDeployArgs)
+	state = newCderState()
+
+	// positional args and CDER_ARGS restored by eval are printed one per line
+	script := "#!/bin/bash\nprintf '%s\\n' \"$@\" > args.txt\neval set -- $CDER_ARGS\nprintf '%s\\n' \"$@\" > env.txt\n"
+	require.Nil(t, ioutil.WriteFile(path.Join(wd, "deploy.sh"), []byte(script), 0755))
+	state.URLs = map[string]*urlState{"https://example.com/manifest": {Args: []string{"--name", "two words", "it's", "$HOME"}}}
+	d := &deployer4sh{wd: wd, repo: "https://example.com/manifest"}
+	d.Deploy(wd)
+
+	actual, err := ioutil.ReadFile(path.Join(wd, "args.txt"))
+	require.Nil(t, err)
+	require.Equal(t, "deploy\n"+wd+"\n--\n--name\ntwo words\nit's\n$HOME\n", string(actual))
+	actual, err = ioutil.ReadFile(path.Join(wd, "env.txt"))
+	require.Nil(t, err)
+	require.Equal(t, "--name\ntwo words\nit's\n$HOME\n", string(actual))
+
+	// `Cder-Args` of the deployed commits are used after cder restart
+	commitDeployArgs = []string{"--port", "8080"}
+	d.DeployAll([]string{wd})
+	require.Equal(t, []string{"--port", "8080"}, state.DeployerArgs)
+	commitDeployArgs = nil
+	d.Start([]string{wd})
+	actual, err = ioutil.ReadFile(path.Join(wd, "args.txt"))
+	require.Nil(t, err)
+	require.Equal(t, "deploy-all\n"+wd+"\n--\n--name\ntwo words\nit's\n$HOME\n--port\n8080\n", string(actual))
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+// deployer4urls deploys artifacts of each url watched by watcherURL using its own deployer4sh
+type deployer4urls struct {
+	// work-dir -> deployer
+	deployers map[string]*deployer4sh
+}
+
+func newDeployer4urls(repos []string) *deployer4urls {
+	res := &deployer4urls{deployers: map[string]*deployer4sh{}}
+	for _, repo := range repos {
+		d := newDeployer4sh(repo)
+		res.deployers[d.wd] = d
+	}
+	return res
+}
+
+func (d *deployer4urls) Deploy(repo string) {
+	d.get(repo).Deploy(repo)
+}
+
+// DeployAll runs `deploy-all` of each url separately, since urls are deployed independently
+func (d *deployer4urls) DeployAll(repos []string) {
+	for _, repo := range repos {
+		d.get(repo).DeployAll([]string{repo})
+	}
+}
+
+func (d *deployer4urls) Start(repos []string) {
+	for _, repo := range repos {
+		d.get(repo).Start([]string{repo})
+	}
+}
+
+func (d *deployer4urls) Stop() {
+	for _, deployer := range d.deployers {
+		deployer.Stop()
+	}
+}
+
+func (d *deployer4urls) get(repoPath string) *deployer4sh {
+	res, ok := d.deployers[repoPath]
+	if !ok {
+		panic("deployer4urls: unknown work-dir " + repoPath)
+	}
+	return res
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	gc "github.com/untillpro/gochips"
+)
+
+const cderArgsTrailer = "Cder-Args:"
+
+var (
+	skipDirectiveRegexp       = regexp.MustCompile(`(?i)\[(skip cd|cd skip)\]`)
+	forceCleanDirectiveRegexp = regexp.MustCompile(`(?i)\[cd force-clean\]`)
+	// arguments from `Cder-Args:` trailers of the commits being deployed. Collected by watcherGit.Watch, used by deployers
+	commitDeployArgs []string
+)
+
+// commitDirectives control deployment of the commits
+type commitDirectives struct {
+	// `[skip cd]` or `[cd skip]` in the message of the newest commit
+	skip bool
+	// `[cd force-clean]` in the message of any commit
+	forceClean bool
+	// `Cder-Args: <args>` trailer of the newest commit
+	args []string
+}
+
+// readCommitDirectives reads messages of commits (fromCommit, toCommit]. Only toCommit is read if fromCommit is empty or unknown
+func readCommitDirectives(repoPath string, fromCommit string, toCommit string) (res commitDirectives) {
+	var stdout string
+	var err error
+	if len(fromCommit) > 0 {
+		stdout, _, err = new(gc.PipedExec).
+			Command("git", "log", "--format=%B%x00", fromCommit+".."+toCommit).
+			WorkingDir(repoPath).
+			RunToStrings()
+	}
+	if len(fromCommit) == 0 || err != nil {
+		stdout, _, err = new(gc.PipedExec).
+			Command("git", "log", "-n", "1", "--format=%B%x00", toCommit).
+			WorkingDir(repoPath).
+			RunToStrings()
+		gc.PanicIfError(err)
+	}
+	for idx, message := range strings.Split(stdout, "\x00") {
+		message = strings.TrimSpace(message)
+		if len(message) == 0 {
+			continue
+		}
+		if forceCleanDirectiveRegexp.MatchString(message) {
+			res.forceClean = true
+		}
+		if idx > 0 {
+			continue
+		}
+		// newest commit goes first
+		res.skip = skipDirectiveRegexp.MatchString(message)
+		res.args = parseCderArgsTrailer(message)
+	}
+	return
+}
+
+// parseCderArgsTrailer returns args from `Cder-Args:` lines of the last paragraph of the message.
+// Args are split like shell does, so quoted args could contain spaces: `Cder-Args: --name "my app"`
+func parseCderArgsTrailer(message string) (args []string) {
+	paragraphs := strings.Split(strings.Replace(message, "\r\n", "\n", -1), "\n\n")
+	if len(paragraphs) < 2 {
+		return nil
+	}
+	for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		if strings.HasPrefix(strings.ToLower(line), strings.ToLower(cderArgsTrailer)) {
+			args = append(args, splitShellArgs(line[len(cderArgsTrailer):])...)
+		}
+	}
+	return
+}
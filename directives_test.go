@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCommitDirectives(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "cder-directives")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	cases := []struct {
+		name string
+		// messages of the deployed commits, oldest first
+		messages []string
+		expected commitDirectives
+	}{
+		{"no directives", []string{"feature", "fix"}, commitDirectives{}},
+		{"skip in newest", []string{"feature", "docs [skip cd]"}, commitDirectives{skip: true}},
+		{"cd skip in newest", []string{"feature", "docs [CD SKIP]"}, commitDirectives{skip: true}},
+		{"skip in older", []string{"docs [skip cd]", "feature"}, commitDirectives{}},
+		{"force-clean in newest", []string{"feature", "fix [cd force-clean]"}, commitDirectives{forceClean: true}},
+		{"force-clean in older", []string{"deps [cd force-clean]", "feature"}, commitDirectives{forceClean: true}},
+		{"args in newest", []string{"feature", "fix\n\nCder-Args: --port 8080"}, commitDirectives{args: []string{"--port", "8080"}}},
+		{"args in older", []string{"feature\n\nCder-Args: --port 8080", "fix"}, commitDirectives{}},
+	}
+	for i, c := range cases {
+		repoDir := path.Join(tempDir, strconv.Itoa(i))
+		initTestRepo(t, repoDir)
+		fromCommit := commitTestRepo(t, repoDir, "initial [skip cd] [cd force-clean]")
+		var toCommit string
+		for _, message := range c.messages {
+			toCommit = commitTestRepo(t, repoDir, message)
+		}
+		require.Equal(t, c.expected, readCommitDirectives(repoDir, fromCommit, toCommit), c.name)
+	}
+
+	// only the newest commit is read if the previous one is unknown
+	repoDir := path.Join(tempDir, "unknown")
+	initTestRepo(t, repoDir)
+	commitTestRepo(t, repoDir, "deps [cd force-clean]")
+	toCommit := commitTestRepo(t, repoDir, "feature")
+	require.Equal(t, commitDirectives{}, readCommitDirectives(repoDir, "", toCommit))
+	require.Equal(t, commitDirectives{}, readCommitDirectives(repoDir, "0123456789abcdef0123456789abcdef01234567", toCommit))
+}
+
+func TestParseCderArgsTrailer(t *testing.T) {
+	cases := []struct {
+		message  string
+		expected []string
+	}{
+		{"fix", nil},
+		{"Cder-Args: --port 8080", nil},
+		{"fix\n\nCder-Args: --port 8080", []string{"--port", "8080"}},
+		{"fix\r\n\r\nCder-Args: --port 8080", []string{"--port", "8080"}},
+		{"fix\n\ncder-args: --verbose", []string{"--verbose"}},
+		{"fix\n\nCder-Args: --name \"my app\" --title 'it is' a\\ b", []string{"--name", "my app", "--title", "it is", "a b"}},
+		{"fix\n\nCder-Args: --message \"it's \\\"quoted\\\"\"", []string{"--message", `it's "quoted"`}},
+		{"fix\n\nCder-Args: --empty ''", []string{"--empty", ""}},
+		{"fix\n\nCder-Args: --port 8080\nSigned-off-by: dev <dev@example.com>\nCder-Args: --verbose", []string{"--port", "8080", "--verbose"}},
+		{"fix\n\nCder-Args: --port 8080\n\nSigned-off-by: dev <dev@example.com>", nil},
+		{"fix\n\nText mentions Cder-Args: --port 8080", nil},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.expected, parseCderArgsTrailer(c.message), c.message)
+	}
+}
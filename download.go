@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	gc "github.com/untillpro/gochips"
+)
+
+const (
+	// artifacts/<url>/download, artifacts and deployer are downloaded to before the current version is cleaned
+	downloadDirName          = "download"
+	downloadPartExt          = ".part"
+	downloadPartInfoExt      = ".part.info"
+	downloadProgressInterval = 5 * time.Second
+)
+
+// downloadMaxSize is the max size of a downloaded file, 0 - unlimited
+var downloadMaxSize int64
+
+// downloadFile streams `url` to `file`. Content is written to `<file>.part` which is renamed to `file` when completed.
+// Interrupted download is resumed by HTTP Range request if the server provided ETag or Last-Modified.
+// `size` is the expected size if known (e.g. OCI layer descriptor), 0 - unknown.
+// Returns false if the url responded with unexpected status
+func downloadFile(client *http.Client, url string, file string, size int64) bool {
+	maxSize := downloadMaxSize
+	if size > 0 && (maxSize == 0 || size < maxSize) {
+		maxSize = size
+	}
+	partFile := file + downloadPartExt
+	partInfoFile := file + downloadPartInfoExt
+	var offset int64
+	validator := readPartInfo(partInfoFile, url)
+	if info, err := os.Stat(partFile); err == nil && len(validator) > 0 {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	gc.PanicIfError(err)
+	authorizeRequest(req)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// server responds with the whole content if it is changed since the part is downloaded
+		req.Header.Set("If-Range", validator)
+	}
+	resp, err := client.Do(req)
+	gc.PanicIfError(err)
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 && contentRangeStart(resp) == offset:
+		gc.Info("downloadFile:", fmt.Sprintf("resuming from %d bytes", offset), url)
+	case resp.StatusCode == http.StatusOK:
+		offset = 0
+	case resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		gc.Info("downloadFile:", "part is not valid anymore, will be downloaded again", url)
+		removeDownloadPart(file)
+		return false
+	default:
+		gc.Info("downloadFile:", fmt.Sprintf("response: %d", resp.StatusCode), url)
+		return false
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+		if maxSize > 0 && total > maxSize {
+			removeDownloadPart(file)
+			panic(fmt.Sprintf("downloadFile: %s: size %d exceeds %d bytes", url, total, maxSize))
+		}
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if offset == 0 {
+		flags |= os.O_TRUNC
+		validator = resp.Header.Get("ETag")
+		if len(validator) == 0 {
+			validator = resp.Header.Get("Last-Modified")
+		}
+		gc.PanicIfError(ioutil.WriteFile(partInfoFile, []byte(url+"\n"+validator), 0644))
+	}
+	f, err := os.OpenFile(partFile, flags, 0644)
+	gc.PanicIfError(err)
+	defer f.Close()
+
+	var body io.Reader = resp.Body
+	if maxSize > 0 {
+		body = io.LimitReader(resp.Body, maxSize-offset+1)
+	}
+	progress := &downloadProgress{url: url, written: offset, total: total, reported: time.Now()}
+	written, err := io.Copy(io.MultiWriter(f, progress), body)
+	// part is kept on failure, so the download is resumed next time
+	gc.PanicIfError(err)
+	gc.PanicIfError(f.Close())
+	if maxSize > 0 && offset+written > maxSize {
+		removeDownloadPart(file)
+		panic(fmt.Sprintf("downloadFile: %s: size exceeds %d bytes", url, maxSize))
+	}
+	if total >= 0 && offset+written != total {
+		panic(fmt.Sprintf("downloadFile: %s: %d of %d bytes downloaded", url, offset+written, total))
+	}
+	if size > 0 && offset+written != size {
+		removeDownloadPart(file)
+		panic(fmt.Sprintf("downloadFile: %s: size %d differs from expected %d bytes", url, offset+written, size))
+	}
+	gc.Verbose("downloadFile", fmt.Sprintf("%s: %d bytes downloaded", url, offset+written))
+	gc.PanicIfError(os.Rename(partFile, file))
+	gc.PanicIfError(os.Remove(partInfoFile))
+	return true
+}
+
+// readPartInfo returns ETag or Last-Modified of the partially downloaded file if it is downloaded from `url`
+func readPartInfo(partInfoFile string, url string) (validator string) {
+	content, err := ioutil.ReadFile(partInfoFile)
+	if err != nil {
+		return ""
+	}
+	lines := strings.SplitN(string(content), "\n", 2)
+	if len(lines) < 2 || lines[0] != url {
+		return ""
+	}
+	return lines[1]
+}
+
+func removeDownloadPart(file string) {
+	os.Remove(file + downloadPartExt)
+	os.Remove(file + downloadPartInfoExt)
+}
+
+// contentRangeStart returns the first byte position of `Content-Range: bytes <start>-<end>/<size>`, -1 if not parsed
+func contentRangeStart(resp *http.Response) int64 {
+	contentRange := strings.TrimPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	dashPos := strings.Index(contentRange, "-")
+	if dashPos < 0 {
+		return -1
+	}
+	start, err := strconv.ParseInt(contentRange[:dashPos], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
+
+// downloadProgress reports download progress in verbose mode each downloadProgressInterval
+type downloadProgress struct {
+	url      string
+	written  int64
+	total    int64
+	reported time.Time
+}
+
+func (p *downloadProgress) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	if gc.IsVerbose && time.Since(p.reported) >= downloadProgressInterval {
+		p.reported = time.Now()
+		if p.total > 0 {
+			gc.Verbose("downloadFile", fmt.Sprintf("%s: %d/%d bytes (%d%%)", p.url, p.written, p.total, p.written*100/p.total))
+		} else {
+			gc.Verbose("downloadFile", fmt.Sprintf("%s: %d bytes", p.url, p.written))
+		}
+	}
+	return len(b), nil
+}
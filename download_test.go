@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "cder-download")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	defer func(maxSize int64) { downloadMaxSize = maxSize }(downloadMaxSize)
+	downloadMaxSize = 0
+
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/artifact.zip" {
+			http.NotFound(w, r)
+			return
+		}
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "artifact.zip", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+	file := path.Join(tempDir, "artifact.zip")
+	url := ts.URL + "/artifact.zip"
+
+	// full
+	require.True(t, downloadFile(http.DefaultClient, url, file, 0))
+	downloaded, err := ioutil.ReadFile(file)
+	require.Nil(t, err)
+	require.Equal(t, content, downloaded)
+	require.False(t, fileExists(file+downloadPartExt))
+	require.False(t, fileExists(file+downloadPartInfoExt))
+
+	// interrupted download is resumed
+	require.Nil(t, ioutil.WriteFile(file+downloadPartExt, content[:4000], 0644))
+	require.Nil(t, ioutil.WriteFile(file+downloadPartInfoExt, []byte(url+"\n"+`"v1"`), 0644))
+	ranges = nil
+	require.True(t, downloadFile(http.DefaultClient, url, file, 0))
+	require.Equal(t, []string{"bytes=4000-"}, ranges)
+	downloaded, err = ioutil.ReadFile(file)
+	require.Nil(t, err)
+	require.Equal(t, content, downloaded)
+
+	// content is changed since the part is downloaded -> downloaded again
+	require.Nil(t, ioutil.WriteFile(file+downloadPartExt, []byte("old content"), 0644))
+	require.Nil(t, ioutil.WriteFile(file+downloadPartInfoExt, []byte(url+"\n"+`"v0"`), 0644))
+	require.True(t, downloadFile(http.DefaultClient, url, file, 0))
+	downloaded, err = ioutil.ReadFile(file)
+	require.Nil(t, err)
+	require.Equal(t, content, downloaded)
+
+	// too large
+	downloadMaxSize = 5000
+	require.Panics(t, func() { downloadFile(http.DefaultClient, url, path.Join(tempDir, "large.zip"), 0) })
+	require.False(t, fileExists(path.Join(tempDir, "large.zip")))
+	require.False(t, fileExists(path.Join(tempDir, "large.zip"+downloadPartExt)))
+
+	// larger or smaller than expected
+	downloadMaxSize = 0
+	require.Panics(t, func() { downloadFile(http.DefaultClient, url, path.Join(tempDir, "sized.zip"), int64(len(content))-1) })
+	require.Panics(t, func() { downloadFile(http.DefaultClient, url, path.Join(tempDir, "sized.zip"), int64(len(content))+1) })
+	require.False(t, fileExists(path.Join(tempDir, "sized.zip"+downloadPartExt)))
+	require.True(t, downloadFile(http.DefaultClient, url, path.Join(tempDir, "sized.zip"), int64(len(content))))
+
+	// not found
+	require.False(t, downloadFile(http.DefaultClient, ts.URL+"/missing", path.Join(tempDir, "missing"), 0))
+}
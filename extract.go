@@ -0,0 +1,352 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	gc "github.com/untillpro/gochips"
+)
+
+// artifact formats
+const (
+	formatZip    = "zip"
+	formatTar    = "tar"
+	formatTarGz  = "tar.gz"
+	formatTarXz  = "tar.xz"
+	formatTarZst = "tar.zst"
+	// single file, e.g. executable
+	formatRaw = "raw"
+)
+
+var (
+	magicZip      = []byte("PK\x03\x04")
+	magicZipEmpty = []byte("PK\x05\x06")
+	magicGzip     = []byte{0x1f, 0x8b}
+	magicXz       = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	magicZstd     = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	// at offset 257
+	magicTar       = []byte("ustar")
+	magicTarOffset = 257
+
+	formatExtensions = []struct {
+		ext    string
+		format string
+	}{
+		{".zip", formatZip},
+		{".tar", formatTar},
+		{".tar.gz", formatTarGz},
+		{".tgz", formatTarGz},
+		{".tar.xz", formatTarXz},
+		{".txz", formatTarXz},
+		{".tar.zst", formatTarZst},
+		{".tzst", formatTarZst},
+	}
+)
+
+// detectArtifactFormat detects format by magic bytes. Extension is used if the content is not recognized:
+// file which is named as an archive must be the archive, anything else is raw
+func detectArtifactFormat(file string, fileName string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	header := make([]byte, magicTarOffset+len(magicTar))
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+	switch {
+	case bytes.HasPrefix(header, magicZip) || bytes.HasPrefix(header, magicZipEmpty):
+		return formatZip, nil
+	case bytes.HasPrefix(header, magicGzip):
+		return formatTarGz, nil
+	case bytes.HasPrefix(header, magicXz):
+		return formatTarXz, nil
+	case bytes.HasPrefix(header, magicZstd):
+		return formatTarZst, nil
+	case len(header) >= magicTarOffset+len(magicTar) && bytes.Equal(header[magicTarOffset:], magicTar):
+		return formatTar, nil
+	}
+	lowerName := strings.ToLower(fileName)
+	for _, item := range formatExtensions {
+		if strings.HasSuffix(lowerName, item.ext) {
+			return "", fmt.Errorf("%s is not a valid %s archive", fileName, item.format)
+		}
+	}
+	return formatRaw, nil
+}
+
+// extraction limits, guard against archive bombs. 0 - unlimited
+var (
+	extractMaxEntries int
+	extractMaxSize    int64
+	extractSymlinks   bool
+)
+
+// extractArtifact extracts `file` to `dir` according to `format`, detected if empty. Raw artifact is copied to `dir` as `fileName`
+func extractArtifact(file string, fileName string, format string, dir string) {
+	if len(format) == 0 {
+		detected, err := detectArtifactFormat(file, fileName)
+		gc.PanicIfError(err)
+		format = detected
+	}
+	gc.Info("extractArtifact:", "Extracting", fileName, "as", format)
+	e := newExtractor(dir)
+	switch format {
+	case formatZip:
+		e.extractZip(file)
+	case formatTar, formatTarGz, formatTarXz, formatTarZst:
+		f, err := os.Open(file)
+		gc.PanicIfError(err)
+		defer f.Close()
+		r := decompress(bufio.NewReader(f), format)
+		defer r.Close()
+		e.extractTar(r)
+	case formatRaw:
+		f, err := os.Open(file)
+		gc.PanicIfError(err)
+		defer f.Close()
+		info, err := f.Stat()
+		gc.PanicIfError(err)
+		e.addEntry()
+		e.writeFile(f, fileName, 0755, info.ModTime())
+	default:
+		panic("extractArtifact: unknown artifact format: " + format)
+	}
+	e.finish()
+}
+
+func decompress(r io.Reader, format string) io.ReadCloser {
+	switch format {
+	case formatTarGz:
+		gzr, err := gzip.NewReader(r)
+		gc.PanicIfError(err)
+		return gzr
+	case formatTarXz:
+		xzr, err := xz.NewReader(r)
+		gc.PanicIfError(err)
+		return ioutil.NopCloser(xzr)
+	case formatTarZst:
+		zr, err := zstd.NewReader(r)
+		gc.PanicIfError(err)
+		return zr.IOReadCloser()
+	}
+	return ioutil.NopCloser(r)
+}
+
+// extractor writes archive entries to `dir`. Entries which escape `dir` are refused, `--extract-max-entries` and `--extract-max-size` are enforced
+type extractor struct {
+	dir string
+	// `dir` with symlinks resolved
+	realDir string
+	entries int
+	size    int64
+	// modes and mtimes are applied to dirs after extraction, otherwise read-only dir could not be filled
+	dirs []extractedDir
+}
+
+type extractedDir struct {
+	path  string
+	mode  os.FileMode
+	mtime time.Time
+}
+
+func newExtractor(dir string) *extractor {
+	gc.PanicIfError(os.MkdirAll(dir, 0755))
+	realDir, err := filepath.EvalSymlinks(dir)
+	gc.PanicIfError(err)
+	realDir, err = filepath.Abs(realDir)
+	gc.PanicIfError(err)
+	return &extractor{dir: dir, realDir: realDir}
+}
+
+func (e *extractor) extractZip(zipFile string) {
+	r, err := zip.OpenReader(zipFile)
+	gc.PanicIfError(err)
+	defer r.Close()
+
+	extractEntry := func(f *zip.File) {
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			e.mkdir(f.Name, mode.Perm(), f.Modified)
+		case mode&os.ModeSymlink != 0:
+			rc, err := f.Open()
+			gc.PanicIfError(err)
+			defer rc.Close()
+			target, err := ioutil.ReadAll(io.LimitReader(rc, 4096))
+			gc.PanicIfError(err)
+			e.symlink(f.Name, string(target))
+		case mode.IsRegular():
+			rc, err := f.Open()
+			gc.PanicIfError(err)
+			defer rc.Close()
+			e.writeFile(rc, f.Name, mode.Perm(), f.Modified)
+		default:
+			gc.Verbose("extractZip", "Unsupported entry is skipped", f.Name)
+		}
+	}
+
+	for _, f := range r.File {
+		e.addEntry()
+		extractEntry(f)
+	}
+}
+
+func (e *extractor) extractTar(r io.Reader) {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return
+		}
+		gc.PanicIfError(err)
+		e.addEntry()
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			e.mkdir(header.Name, mode, header.ModTime)
+		case tar.TypeReg, tar.TypeRegA:
+			e.writeFile(tr, header.Name, mode, header.ModTime)
+		case tar.TypeSymlink:
+			e.symlink(header.Name, header.Linkname)
+		case tar.TypeLink:
+			e.link(header.Name, header.Linkname)
+		default:
+			gc.Verbose("extractTar", "Unsupported entry is skipped", header.Name)
+		}
+	}
+}
+
+func (e *extractor) addEntry() {
+	e.entries++
+	if extractMaxEntries > 0 && e.entries > extractMaxEntries {
+		panic(fmt.Sprintf("extractArtifact: archive has more than %d entries", extractMaxEntries))
+	}
+}
+
+// entryPath returns the path to extract entry `name` to. Panics if the entry escapes `dir`
+func (e *extractor) entryPath(name string) string {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) || strings.HasPrefix(name, string(filepath.Separator)) || len(filepath.VolumeName(name)) > 0 {
+		panic("extractArtifact: entry has absolute path: " + name)
+	}
+	res := filepath.Join(e.dir, name)
+	if !isInsideDir(e.dir, res) {
+		panic("extractArtifact: entry escapes target dir: " + name)
+	}
+	gc.PanicIfError(os.MkdirAll(filepath.Dir(res), 0755))
+	// parent dir could be reached through a symlink extracted before
+	realParent, err := filepath.EvalSymlinks(filepath.Dir(res))
+	gc.PanicIfError(err)
+	realParent, err = filepath.Abs(realParent)
+	gc.PanicIfError(err)
+	if !isInsideDir(e.realDir, realParent) {
+		panic("extractArtifact: entry escapes target dir through symlink: " + name)
+	}
+	return filepath.Join(realParent, filepath.Base(res))
+}
+
+func (e *extractor) mkdir(name string, mode os.FileMode, mtime time.Time) {
+	path := e.entryPath(name)
+	gc.PanicIfError(os.MkdirAll(path, 0755))
+	e.dirs = append(e.dirs, extractedDir{path: path, mode: mode, mtime: mtime})
+}
+
+func (e *extractor) writeFile(r io.Reader, name string, mode os.FileMode, mtime time.Time) {
+	path := e.entryPath(name)
+	removeIfLink(path)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	gc.PanicIfError(err)
+	defer f.Close()
+	// sizes from headers are not trusted, actual content is counted
+	if extractMaxSize > 0 {
+		r = io.LimitReader(r, extractMaxSize-e.size+1)
+	}
+	written, err := io.Copy(f, r)
+	gc.PanicIfError(err)
+	e.size += written
+	if extractMaxSize > 0 && e.size > extractMaxSize {
+		panic(fmt.Sprintf("extractArtifact: extracted size exceeds %d bytes", extractMaxSize))
+	}
+	gc.PanicIfError(f.Close())
+	// mode is set explicitly since OpenFile applies umask
+	gc.PanicIfError(os.Chmod(path, mode))
+	if !mtime.IsZero() {
+		gc.PanicIfError(os.Chtimes(path, mtime, mtime))
+	}
+}
+
+// symlink creates the symlink if `--extract-symlinks` is specified and `target` stays inside `dir`
+func (e *extractor) symlink(name string, target string) {
+	if !extractSymlinks {
+		gc.Verbose("extractArtifact", "Symlink is skipped, see --extract-symlinks", name)
+		return
+	}
+	path := e.entryPath(name)
+	// cleaned, so `..` could be only leading and symlinks are never traversed upwards
+	target = filepath.Clean(filepath.FromSlash(target))
+	if filepath.IsAbs(target) || !isInsideDir(e.realDir, filepath.Join(filepath.Dir(path), target)) {
+		panic(fmt.Sprintf("extractArtifact: symlink %s -> %s escapes target dir", name, target))
+	}
+	gc.PanicIfError(os.RemoveAll(path))
+	gc.PanicIfError(os.Symlink(target, path))
+}
+
+// link creates hard link `name` to entry `target` extracted before
+func (e *extractor) link(name string, target string) {
+	targetPath := e.entryPath(target)
+	targetInfo, err := os.Lstat(targetPath)
+	gc.PanicIfError(err)
+	if !targetInfo.Mode().IsRegular() {
+		panic(fmt.Sprintf("extractArtifact: hard link %s -> %s: regular file expected", name, target))
+	}
+	path := e.entryPath(name)
+	removeIfLink(path)
+	gc.PanicIfError(os.RemoveAll(path))
+	gc.PanicIfError(os.Link(targetPath, path))
+}
+
+// finish applies modes and mtimes to extracted dirs, deepest first. Owner always keeps access so work-dir could be cleaned
+func (e *extractor) finish() {
+	for i := len(e.dirs) - 1; i >= 0; i-- {
+		d := e.dirs[i]
+		gc.PanicIfError(os.Chmod(d.path, d.mode|0700))
+		if !d.mtime.IsZero() {
+			gc.PanicIfError(os.Chtimes(d.path, d.mtime, d.mtime))
+		}
+	}
+}
+
+// removeIfLink removes `path` if it is a symlink, so writing to it does not affect its target
+func removeIfLink(path string) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		gc.PanicIfError(os.Remove(path))
+	}
+}
+
+func isInsideDir(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
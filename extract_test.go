@@ -0,0 +1,213 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+func TestExtractArtifact(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "cder-extract")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+
+	tarBuf := new(bytes.Buffer)
+	tw := tar.NewWriter(tarBuf)
+	require.Nil(t, tw.WriteHeader(&tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755}))
+	require.Nil(t, tw.WriteHeader(&tar.Header{Name: "bin/app", Typeflag: tar.TypeReg, Mode: 0755, Size: 5}))
+	_, err = tw.Write([]byte("hello"))
+	require.Nil(t, err)
+	require.Nil(t, tw.Close())
+
+	zipBuf := new(bytes.Buffer)
+	zw := zip.NewWriter(zipBuf)
+	f, err := zw.Create("bin/app")
+	require.Nil(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.Nil(t, err)
+	require.Nil(t, zw.Close())
+
+	compress := func(newWriter func(io.Writer) io.WriteCloser) []byte {
+		buf := new(bytes.Buffer)
+		w := newWriter(buf)
+		_, err := w.Write(tarBuf.Bytes())
+		require.Nil(t, err)
+		require.Nil(t, w.Close())
+		return buf.Bytes()
+	}
+	gzBytes := compress(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+	xzBytes := compress(func(w io.Writer) io.WriteCloser {
+		xw, err := xz.NewWriter(w)
+		require.Nil(t, err)
+		return xw
+	})
+	zstBytes := compress(func(w io.Writer) io.WriteCloser {
+		zw, err := zstd.NewWriter(w)
+		require.Nil(t, err)
+		return zw
+	})
+
+	for _, c := range []struct {
+		fileName string
+		content  []byte
+		format   string
+	}{
+		{"app.zip", zipBuf.Bytes(), formatZip},
+		{"app.tar", tarBuf.Bytes(), formatTar},
+		{"app.tar.gz", gzBytes, formatTarGz},
+		{"app.tar.xz", xzBytes, formatTarXz},
+		{"app.tar.zst", zstBytes, formatTarZst},
+		// detected by content
+		{"app-linux-amd64", gzBytes, formatTarGz},
+	} {
+		file := path.Join(tempDir, c.fileName)
+		require.Nil(t, ioutil.WriteFile(file, c.content, 0644))
+		format, err := detectArtifactFormat(file, c.fileName)
+		require.Nil(t, err, c.fileName)
+		require.Equal(t, c.format, format, c.fileName)
+
+		dir := path.Join(tempDir, "wd-"+c.fileName)
+		extractArtifact(file, c.fileName, "", dir)
+		content, err := ioutil.ReadFile(path.Join(dir, "bin/app"))
+		require.Nil(t, err, c.fileName)
+		require.Equal(t, "hello", string(content))
+	}
+
+	// raw
+	file := path.Join(tempDir, "app")
+	require.Nil(t, ioutil.WriteFile(file, []byte("#!/bin/sh\n"), 0644))
+	dir := path.Join(tempDir, "wd-raw")
+	extractArtifact(file, "app", "", dir)
+	info, err := os.Stat(path.Join(dir, "app"))
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	// named as an archive but it is not
+	_, err = detectArtifactFormat(file, "app.tar.gz")
+	require.NotNil(t, err)
+}
+
+func TestExtractArtifactSafety(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "cder-extract")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	defer func(maxEntries int, maxSize int64, symlinks bool) {
+		extractMaxEntries, extractMaxSize, extractSymlinks = maxEntries, maxSize, symlinks
+	}(extractMaxEntries, extractMaxSize, extractSymlinks)
+	extractMaxEntries, extractMaxSize, extractSymlinks = 10, 100, true
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	extract := func(name string, headers ...*tar.Header) (string, error) {
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		for _, h := range headers {
+			content := []byte("hello")
+			if h.Size > 0 {
+				content = bytes.Repeat([]byte("x"), int(h.Size))
+			}
+			if h.Typeflag == tar.TypeReg {
+				h.Size = int64(len(content))
+			}
+			require.Nil(t, tw.WriteHeader(h))
+			if h.Typeflag == tar.TypeReg {
+				_, err := tw.Write(content)
+				require.Nil(t, err)
+			}
+		}
+		require.Nil(t, tw.Close())
+		file := path.Join(tempDir, name+".tar")
+		require.Nil(t, ioutil.WriteFile(file, buf.Bytes(), 0644))
+		dir := path.Join(tempDir, "wd-"+name)
+		return dir, func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("%v", r)
+				}
+			}()
+			extractArtifact(file, name+".tar", "", dir)
+			return nil
+		}()
+	}
+	reg := func(name string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0640, ModTime: mtime}
+	}
+	symlink := func(name string, target string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: target}
+	}
+
+	// modes, mtimes and symlinks inside work-dir
+	dir, err := extract("ok",
+		&tar.Header{Name: "conf/", Typeflag: tar.TypeDir, Mode: 0750, ModTime: mtime},
+		reg("conf/app.conf"),
+		symlink("app.conf", "conf/app.conf"),
+		&tar.Header{Name: "app-link.conf", Typeflag: tar.TypeLink, Linkname: "conf/app.conf"},
+	)
+	require.Nil(t, err)
+	info, err := os.Stat(path.Join(dir, "conf"))
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0750), info.Mode().Perm())
+	require.True(t, mtime.Equal(info.ModTime()))
+	info, err = os.Stat(path.Join(dir, "conf/app.conf"))
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	require.True(t, mtime.Equal(info.ModTime()))
+	for _, name := range []string{"app.conf", "app-link.conf"} {
+		content, err := ioutil.ReadFile(path.Join(dir, name))
+		require.Nil(t, err)
+		require.Equal(t, "hello", string(content))
+	}
+
+	// refused
+	for name, headers := range map[string][]*tar.Header{
+		"slip":          {reg("../evil")},
+		"absolute":      {reg("/tmp/evil")},
+		"symlink-out":   {symlink("evil", "../..")},
+		"symlink-abs":   {symlink("evil", "/etc")},
+		"symlink-chain": {symlink("a", "."), symlink("b", "a/../.."), reg("b/evil")},
+		"link-out":      {{Name: "evil", Typeflag: tar.TypeLink, Linkname: "../outside"}},
+		"entries":       {reg("1"), reg("2"), reg("3"), reg("4"), reg("5"), reg("6"), reg("7"), reg("8"), reg("9"), reg("10"), reg("11")},
+		"size":          {reg("1"), {Name: "big", Typeflag: tar.TypeReg, Size: 96}},
+	} {
+		_, err := extract(name, headers...)
+		require.NotNil(t, err, name)
+	}
+	require.False(t, fileExists(path.Join(tempDir, "evil")))
+
+	// symlinks are skipped by default
+	extractSymlinks = false
+	dir, err = extract("no-symlinks", symlink("evil", "/etc"))
+	require.Nil(t, err)
+	_, err = os.Lstat(path.Join(dir, "evil"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestParseArtifactURL(t *testing.T) {
+	for url, expected := range map[string]string{
+		"https://example.com/app-1.2.0.zip":    "app-1.2.0",
+		"https://example.com/app-1.2.0.tar.gz": "app-1.2.0",
+		"https://example.com/app":              "app",
+		"https://example.com/":                 "artifact",
+	} {
+		aPath, _ := parseArtifactURL(url)
+		require.Equal(t, expected, path.Base(aPath), url)
+	}
+}
@@ -28,41 +28,171 @@ var (
 	gURL   string
 )
 
+const gotifyMessagesPageSize = 100
+
+// gitTrackerGotify polls Gotify server. Messages of all apps are fetched by single request per iteration
 type gitTrackerGotify struct {
-	notfications chan string
-	not          map[string]chan string
+	client *client.GotifyREST
+	// app name (repoURL) -> app, refreshed on miss only
+	apps map[string]*models.Application
+	// app IDs which newest messages are fetched
+	trackedApps map[uint]bool
+	// app ID -> newest message
+	lastMessages map[uint]*models.MessageExternal
+	// newest message ID over all apps, messages are fetched since it
+	lastMessageID uint
+	initialized   bool
+	// messages are not fetched yet during the current iteration, see StartIteration
+	fetchPending bool
+}
+
+func newGitTrackerGotify() *gitTrackerGotify {
+	return &gitTrackerGotify{
+		apps:         map[string]*models.Application{},
+		trackedApps:  map[uint]bool{},
+		lastMessages: map[uint]*models.MessageExternal{},
+	}
+}
+
+// StartIteration makes the next asked repo fetch messages of all apps, the rest repos of the iteration use them
+func (wcn *gitTrackerGotify) StartIteration() {
+	wcn.fetchPending = true
 }
 
-// note: websocket stream
+// The repo is fetched and reset to the notified commit. Wrong commit is refused, the deployed one is kept
 func (wcn *gitTrackerGotify) GetLastCommit(repoURL string, repoPath string) (lastCommit string, ok bool) {
-	gotifyClient := getGotifyClient(gURL)
-	authInfo := auth.TokenAuth(gToken)
-	appsResponse, err := gotifyClient.Application.GetApps(nil, authInfo)
+	lastMessage := wcn.getLastMessage(repoURL)
+	if lastMessage == nil {
+		return "", false
+	}
+	gitURL, _ := parseRepoURL(repoURL)
+	return checkoutNotifiedCommit(gitURL, repoPath, parseDeployPayload(lastMessage).Commit)
+}
+
+// getLastMessage returns the newest accepted message of the app named `appName`, nil if there are no messages
+func (wcn *gitTrackerGotify) getLastMessage(appName string) *models.MessageExternal {
+	if wcn.client == nil {
+		wcn.client = getGotifyClient(gURL)
+	}
+	if wcn.fetchPending {
+		wcn.fetchMessages()
+		wcn.fetchPending = false
+	}
+
+	app := wcn.getApp(appName)
+	if !wcn.trackedApps[app.ID] {
+		wcn.fetchAppMessages(app)
+	}
+	return wcn.lastMessages[app.ID]
+}
+
+// getApp returns cached app named `appName`, created if does not exist. Apps are requested again on miss
+func (wcn *gitTrackerGotify) getApp(appName string) *models.Application {
+	if app, ok := wcn.apps[appName]; ok {
+		return app
+	}
+	appsResponse, err := wcn.client.Application.GetApps(nil, auth.TokenAuth(gToken))
 	gc.PanicIfError(err)
-	app := findApp(appsResponse.Payload, repoURL)
-	if app == nil {
-		// create application
-		createAppParams := application.NewCreateAppParams().WithBody(&models.Application{
-			Name:        repoURL,
-			Description: "Created by cder " + time.Now().Format(time.RFC3339),
-		})
-		createAppResponse, err := gotifyClient.Application.CreateApp(createAppParams, authInfo)
-		gc.PanicIfError(err)
-		app = createAppResponse.Payload
-		printPushVerCommand(app)
+	for _, app := range appsResponse.Payload {
+		wcn.apps[app.Name] = app
+	}
+	app, ok := wcn.apps[appName]
+	if !ok {
+		app = createGotifyApp(wcn.client, appName)
+		wcn.apps[appName] = app
 	}
+	return app
+}
 
+// fetchAppMessages fetches last messages of the newly tracked app, since messages older than `lastMessageID` are not fetched anymore
+func (wcn *gitTrackerGotify) fetchAppMessages(app *models.Application) {
 	appMessagesParams := message.NewGetAppMessagesParams()
 	appMessagesParams.ID = int64(app.ID)
-	limit := int64(1)
+	limit := int64(gotifyMessagesPageSize)
 	appMessagesParams.Limit = &limit
-	appMessagesResponse, err := gotifyClient.Message.GetAppMessages(appMessagesParams, authInfo)
+	appMessagesResponse, err := wcn.client.Message.GetAppMessages(appMessagesParams, auth.TokenAuth(gToken))
+	gc.PanicIfError(err)
+	for _, msg := range appMessagesResponse.Payload.Messages {
+		wcn.onMessage(msg)
+	}
+	wcn.trackedApps[app.ID] = true
+}
+
+// fetchMessages gets messages of all apps which are newer than `lastMessageID`.
+// Gotify returns messages from the newest one, `since` means "older than", so pages are requested down to `lastMessageID`.
+// First call just remembers the newest message ID
+func (wcn *gitTrackerGotify) fetchMessages() {
+	limit := int64(gotifyMessagesPageSize)
+	if !wcn.initialized {
+		limit = 1
+	}
+	newestID := wcn.lastMessageID
+	var since int64
+	for {
+		messagesParams := message.NewGetMessagesParams()
+		messagesParams.Limit = &limit
+		if since > 0 {
+			messagesParams.Since = &since
+		}
+		messagesResponse, err := wcn.client.Message.GetMessages(messagesParams, auth.TokenAuth(gToken))
+		gc.PanicIfError(err)
+		messages := messagesResponse.Payload.Messages
+		for _, msg := range messages {
+			if msg.ID <= wcn.lastMessageID {
+				break
+			}
+			if msg.ID > newestID {
+				newestID = msg.ID
+			}
+			wcn.onMessage(msg)
+		}
+		if !wcn.initialized || len(messages) < int(limit) || messages[len(messages)-1].ID <= wcn.lastMessageID {
+			break
+		}
+		since = int64(messages[len(messages)-1].ID)
+	}
+	gc.Verbose("gitTrackerGotify", "Messages fetched", wcn.lastMessageID, newestID)
+	wcn.lastMessageID = newestID
+	wcn.initialized = true
+}
+
+// onMessage keeps the newest message of each known app. Messages which payload is not intended for the app repo are ignored
+func (wcn *gitTrackerGotify) onMessage(msg *models.MessageExternal) {
+	var app *models.Application
+	for _, knownApp := range wcn.apps {
+		if knownApp.ID == msg.ApplicationID {
+			app = knownApp
+		}
+	}
+	if app == nil || !parseDeployPayload(msg).accepts(app.Name) {
+		return
+	}
+	if lastMessage, ok := wcn.lastMessages[msg.ApplicationID]; !ok || lastMessage.ID < msg.ID {
+		wcn.lastMessages[msg.ApplicationID] = msg
+	}
+}
+
+// getGotifyApp returns the application named by `repoURL`. Created if does not exist
+func getGotifyApp(gotifyClient *client.GotifyREST, repoURL string) *models.Application {
+	appsResponse, err := gotifyClient.Application.GetApps(nil, auth.TokenAuth(gToken))
 	gc.PanicIfError(err)
-	ok = len(appMessagesResponse.Payload.Messages) != 0
-	if ok {
-		lastCommit = appMessagesResponse.Payload.Messages[0].Title
+	app := findApp(appsResponse.Payload, repoURL)
+	if app == nil {
+		app = createGotifyApp(gotifyClient, repoURL)
 	}
-	return
+	return app
+}
+
+// createGotifyApp creates the application named by `repoURL` and prints the command to push to it
+func createGotifyApp(gotifyClient *client.GotifyREST, repoURL string) *models.Application {
+	createAppParams := application.NewCreateAppParams().WithBody(&models.Application{
+		Name:        repoURL,
+		Description: "Created by cder " + time.Now().Format(time.RFC3339),
+	})
+	createAppResponse, err := gotifyClient.Application.CreateApp(createAppParams, auth.TokenAuth(gToken))
+	gc.PanicIfError(err)
+	printPushVerCommand(createAppResponse.Payload)
+	return createAppResponse.Payload
 }
 
 func getGotifyClient(rawURL string) *client.GotifyREST {
@@ -81,7 +211,7 @@ func findApp(apps []*models.Application, appName string) *models.Application {
 }
 
 func printPushVerCommand(app *models.Application) {
-	// $ curl "https://push.example.de/message?token=<apptoken>" -F "title=<version>" -F "message=<url>"
+	// $ curl "https://push.example.de/message?token=<apptoken>" -H "Content-Type: application/json" -d '{"title": "<commit>", "message": "<commit>", "extras": {"cder::deploy": {...}}}'
 	parsedURL, err := url.Parse(gURL)
 	gc.PanicIfError(err)
 	parsedURL.Path = path.Join(parsedURL.Path, "message")
@@ -91,5 +221,7 @@ func printPushVerCommand(app *models.Application) {
 		pq.Add("token", app.Token)
 		parsedURL.RawQuery = pq.Encode()
 	}
-	log.Println("Command for push versions: curl \"" + parsedURL.String() + "\" -F \"title=<version>\" -F \"message=<url>\"")
+	log.Println("Command for push commits: curl \"" + parsedURL.String() + "\" -F \"title=<commit>\" -F \"message=<commit>\"")
+	log.Println("Command for push artifacts: curl \"" + parsedURL.String() + "\" -H \"Content-Type: application/json\" -d " +
+		`'{"title": "<version>", "message": "<version>", "extras": {"` + gotifyExtrasKey + `": {"commit": "<commit>", "branch": "<branch>", "artifactURL": "<url>", "checksum": "<sha256>", "environment": "<environment>"}}}'`)
 }
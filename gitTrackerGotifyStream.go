@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/gotify/go-api-client/v2/auth"
+	"github.com/gotify/go-api-client/v2/client/message"
+	"github.com/gotify/go-api-client/v2/models"
+	gc "github.com/untillpro/gochips"
+)
+
+var gotifyStream bool
+
+const (
+	gotifyStreamReadTimeout    = 2 * time.Minute // Gotify pings each 45 seconds
+	gotifyStreamMaxReconnect   = 30 * time.Second
+	gotifyStreamFirstReconnect = time.Second
+)
+
+// gitTrackerGotifyStream keeps websocket subscription to Gotify `/stream` open to get notifications immediately.
+// Stream misses messages pushed while it is not connected, so on each (re)connect apps messages are reconciled using REST API
+type gitTrackerGotifyStream struct {
+	mu sync.Mutex
+	// repoURL -> app ID
+	apps map[string]uint
+	// app ID -> last seen message
+	lastMessages map[uint]*models.MessageExternal
+	started      bool
+}
+
+func (t *gitTrackerGotifyStream) GetLastCommit(repoURL string, repoPath string) (lastCommit string, ok bool) {
+	t.mu.Lock()
+	appID, registered := t.apps[repoURL]
+	t.mu.Unlock()
+	if !registered {
+		app := getGotifyApp(getGotifyClient(gURL), repoURL)
+		appID = app.ID
+		t.mu.Lock()
+		t.apps[repoURL] = appID
+		t.mu.Unlock()
+		t.reconcile(appID)
+	}
+	t.startOnce()
+
+	t.mu.Lock()
+	lastMessage, ok := t.lastMessages[appID]
+	t.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+	gitURL, _ := parseRepoURL(repoURL)
+	return checkoutNotifiedCommit(gitURL, repoPath, parseDeployPayload(lastMessage).Commit)
+}
+
+func (t *gitTrackerGotifyStream) startOnce() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.started {
+		t.started = true
+		go t.stream(ctx)
+	}
+}
+
+// stream (re)connects to Gotify `/stream` until `ctx` is done i.e. cder is stopped
+func (t *gitTrackerGotifyStream) stream(ctx context.Context) {
+	reconnectDelay := gotifyStreamFirstReconnect
+	for ctx.Err() == nil {
+		connected, err := t.readStream(ctx)
+		if connected {
+			reconnectDelay = gotifyStreamFirstReconnect
+		}
+		if err != nil {
+			gc.Error("gitTrackerGotifyStream: stream error, reconnecting in", reconnectDelay, err)
+		}
+		select {
+		case <-time.After(reconnectDelay):
+		case <-ctx.Done():
+			return
+		}
+		if reconnectDelay *= 2; reconnectDelay > gotifyStreamMaxReconnect {
+			reconnectDelay = gotifyStreamMaxReconnect
+		}
+	}
+}
+
+// readStream reads the stream till it is broken. `connected` means the stream was established, so reconnect delay is reset
+func (t *gitTrackerGotifyStream) readStream(ctx context.Context) (connected bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			gc.Error("gitTrackerGotifyStream: Recovered: ", r)
+		}
+	}()
+	header := http.Header{}
+	header.Set("X-Gotify-Key", gToken)
+	conn, _, err := websocket.DefaultDialer.Dial(getGotifyStreamURL(gURL), header)
+	if err != nil {
+		return false, err
+	}
+	connected = true
+	// unblocks ReadMessage on stop, released when the connection is closed
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		conn.Close()
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	gc.Info("gitTrackerGotifyStream:", "Connected to the stream")
+
+	conn.SetReadDeadline(time.Now().Add(gotifyStreamReadTimeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(gotifyStreamReadTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	// messages pushed before the connection are received using REST, later ones come from the stream
+	t.mu.Lock()
+	appIDs := make([]uint, 0, len(t.apps))
+	for _, appID := range t.apps {
+		appIDs = append(appIDs, appID)
+	}
+	t.mu.Unlock()
+	for _, appID := range appIDs {
+		t.reconcile(appID)
+	}
+
+	for {
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			return true, err
+		}
+		conn.SetReadDeadline(time.Now().Add(gotifyStreamReadTimeout))
+		msg := &models.MessageExternal{}
+		if err := json.Unmarshal(payload, msg); err != nil {
+			gc.Error("gitTrackerGotifyStream: wrong message is ignored:", err)
+			continue
+		}
+		t.onMessage(msg)
+	}
+}
+
+// reconcile gets last messages of the app using REST API
+func (t *gitTrackerGotifyStream) reconcile(appID uint) {
+	appMessagesParams := message.NewGetAppMessagesParams()
+	appMessagesParams.ID = int64(appID)
+	limit := int64(gotifyMessagesPageSize)
+	appMessagesParams.Limit = &limit
+	appMessagesResponse, err := getGotifyClient(gURL).Message.GetAppMessages(appMessagesParams, auth.TokenAuth(gToken))
+	gc.PanicIfError(err)
+	for _, msg := range appMessagesResponse.Payload.Messages {
+		t.onMessage(msg)
+	}
+}
+
+// onMessage stores the message if it is newer than the last seen one of the tracked app and wakes the seeder up.
+// Messages which payload is not intended for the app repo are ignored
+func (t *gitTrackerGotifyStream) onMessage(msg *models.MessageExternal) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tracked := false
+	for repoURL, appID := range t.apps {
+		tracked = tracked || appID == msg.ApplicationID && parseDeployPayload(msg).accepts(repoURL)
+	}
+	if !tracked {
+		return
+	}
+	if lastMessage, ok := t.lastMessages[msg.ApplicationID]; ok && lastMessage.ID >= msg.ID {
+		return
+	}
+	t.lastMessages[msg.ApplicationID] = msg
+	gc.Info("gitTrackerGotifyStream:", "New message", msg.ApplicationID, msg.ID, msg.Title)
+	wakeUp()
+}
+
+// getGotifyStreamURL changes url "http(s)://server.url" -> "ws(s)://server.url/stream"
+func getGotifyStreamURL(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+	gc.PanicIfError(err)
+	if parsedURL.Scheme == "http" {
+		parsedURL.Scheme = "ws"
+	} else {
+		parsedURL.Scheme = "wss"
+	}
+	parsedURL.Path = path.Join(parsedURL.Path, "stream")
+	return parsedURL.String()
+}
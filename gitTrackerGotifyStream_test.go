@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/gotify/go-api-client/v2/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitTrackerGotifyStreamReconnect(t *testing.T) {
+	// Gotify stub: REST messages of app 1 and `/stream` which connections are passed to the test
+	var mu sync.Mutex
+	var messages []*models.MessageExternal
+	postMessage := func(id uint, commit string) *models.MessageExternal {
+		mu.Lock()
+		defer mu.Unlock()
+		msg := &models.MessageExternal{ID: id, ApplicationID: 1, Title: commit, Message: commit}
+		messages = append([]*models.MessageExternal{msg}, messages...)
+		return msg
+	}
+	connections := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/application":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[{"id": 1, "name": "app1"}]`))
+		case "/application/1/message":
+			mu.Lock()
+			defer mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(&models.PagedMessages{Messages: messages, Paging: models.Paging{Size: len(messages), Limit: 100}})
+		case "/stream":
+			conn, err := upgrader.Upgrade(w, r, nil)
+			require.Nil(t, err)
+			connections <- conn
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	defer func(url string, c context.Context, cf context.CancelFunc) { gURL, ctx, cancel = url, c, cf }(gURL, ctx, cancel)
+	gURL = ts.URL
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	wokenUp := func() bool {
+		select {
+		case <-wakeUpCh:
+			return true
+		case <-time.After(5 * time.Second):
+			return false
+		}
+	}
+
+	postMessage(1, "1111111")
+	tracker := &gitTrackerGotifyStream{
+		apps:         map[string]uint{},
+		lastMessages: map[uint]*models.MessageExternal{},
+	}
+	lastCommit, ok := tracker.GetLastCommit("app1", "")
+	require.True(t, ok)
+	require.Equal(t, "1111111", lastCommit)
+	conn := <-connections
+	<-wakeUpCh
+
+	// message from the stream wakes the seeder up
+	require.Nil(t, conn.WriteJSON(postMessage(2, "2222222")))
+	require.True(t, wokenUp())
+	lastCommit, _ = tracker.GetLastCommit("app1", "")
+	require.Equal(t, "2222222", lastCommit)
+
+	// message posted while the stream is disconnected is delivered by reconcile on reconnect.
+	// Reconnect delay is reset after each established connection, so each reconnect takes the first delay
+	for i, commit := range []string{"3333333", "4444444"} {
+		conn.Close()
+		dropped := time.Now()
+		postMessage(uint(3+i), commit)
+		select {
+		case conn = <-connections:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "stream is not reconnected")
+		}
+		require.Less(t, int64(time.Since(dropped)), int64(gotifyStreamFirstReconnect*3/2))
+		require.True(t, wokenUp())
+		lastCommit, _ = tracker.GetLastCommit("app1", "")
+		require.Equal(t, commit, lastCommit)
+	}
+	conn.Close()
+}
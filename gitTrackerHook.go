@@ -0,0 +1,194 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	gc "github.com/untillpro/gochips"
+)
+
+var (
+	hookListen string
+	hookSecret string
+)
+
+const (
+	hookMaxPayloadSize = 25 * 1024 * 1024 // GitHub caps payloads at 25 MB
+	hookZeroCommit     = "0000000000000000000000000000000000000000"
+)
+
+// gitTrackerHook receives push events from GitHub, Gitea and GitLab webhooks
+type gitTrackerHook struct {
+	mu sync.Mutex
+	// normalizeRepoURL(url) + "#" + ref -> head commit. Empty ref means the default branch
+	commits map[string]string
+}
+
+// hookPushEvent holds fields of GitHub, Gitea and GitLab push event payloads which are used by cder
+type hookPushEvent struct {
+	Ref         string `json:"ref"`
+	After       string `json:"after"`
+	CheckoutSha string `json:"checkout_sha"` // GitLab
+	Repository  struct {
+		CloneURL      string `json:"clone_url"`
+		HTMLURL       string `json:"html_url"`
+		SSHURL        string `json:"ssh_url"`
+		GitHTTPURL    string `json:"git_http_url"` // GitLab
+		Homepage      string `json:"homepage"`     // GitLab
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+	Project struct { // GitLab
+		WebURL        string `json:"web_url"`
+		GitHTTPURL    string `json:"git_http_url"`
+		DefaultBranch string `json:"default_branch"`
+	} `json:"project"`
+}
+
+func (t *gitTrackerHook) GetLastCommit(repoURL string, repoPath string) (lastCommit string, ok bool) {
+	hookURL, ref := parseRepoURL(repoURL)
+	if len(ref.commit) > 0 {
+		return ref.commit, true
+	}
+	hookRef := ""
+	if len(ref.branch) > 0 {
+		hookRef = "refs/heads/" + ref.branch
+	} else if len(ref.tag) > 0 {
+		hookRef = "refs/tags/" + ref.tag
+	}
+	t.mu.Lock()
+	lastCommit, ok = t.commits[normalizeRepoURL(hookURL)+"#"+hookRef]
+	t.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+	return checkoutNotifiedCommit(hookURL, repoPath, lastCommit)
+}
+
+// listen starts receiving webhooks on `addr` in background
+func (t *gitTrackerHook) listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	gc.Info("gitTrackerHook:", "Listening for webhooks on", listener.Addr().String())
+	go func() {
+		gc.Error("gitTrackerHook:", http.Serve(listener, t))
+	}()
+	return nil
+}
+
+func (t *gitTrackerHook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, hookMaxPayloadSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !verifyHookRequest(r.Header, body, hookSecret) {
+		gc.Error("gitTrackerHook:", "Signature verification failed, request from "+r.RemoteAddr+" is ignored")
+		http.Error(w, "signature verification failed", http.StatusUnauthorized)
+		return
+	}
+	if !isHookPushEvent(r.Header) {
+		gc.Verbose("gitTrackerHook", "Not a push event, ignored")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	event := &hookPushEvent{}
+	if err := json.Unmarshal(body, event); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t.recordPush(event)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (t *gitTrackerHook) recordPush(event *hookPushEvent) {
+	commit := event.After
+	if len(event.CheckoutSha) > 0 {
+		commit = event.CheckoutSha
+	}
+	if len(commit) == 0 || commit == hookZeroCommit {
+		gc.Verbose("gitTrackerHook", "Ref deleted or no commits, ignored", event.Ref)
+		return
+	}
+	defaultBranch := event.Repository.DefaultBranch
+	if len(defaultBranch) == 0 {
+		defaultBranch = event.Project.DefaultBranch
+	}
+	repoURLs := []string{event.Repository.CloneURL, event.Repository.HTMLURL, event.Repository.SSHURL,
+		event.Repository.GitHTTPURL, event.Repository.Homepage, event.Project.WebURL, event.Project.GitHTTPURL}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, repoURL := range repoURLs {
+		if len(repoURL) == 0 {
+			continue
+		}
+		key := normalizeRepoURL(repoURL)
+		t.commits[key+"#"+event.Ref] = commit
+		if event.Ref == "refs/heads/"+defaultBranch {
+			t.commits[key+"#"] = commit
+		}
+	}
+	gc.Info("gitTrackerHook:", "Push received", event.Repository.HTMLURL+event.Project.WebURL, event.Ref, commit)
+	wakeUp()
+}
+
+func isHookPushEvent(header http.Header) bool {
+	if event := header.Get("X-GitHub-Event"); len(event) > 0 {
+		return event == "push"
+	}
+	if event := header.Get("X-Gitea-Event"); len(event) > 0 {
+		return event == "push"
+	}
+	if event := header.Get("X-Gitlab-Event"); len(event) > 0 {
+		return event == "Push Hook" || event == "Tag Push Hook"
+	}
+	return false
+}
+
+// verifyHookRequest checks signature made by GitHub or Gitea or token sent by GitLab
+func verifyHookRequest(header http.Header, body []byte, secret string) bool {
+	if len(secret) == 0 {
+		return false
+	}
+	if signature := header.Get("X-Hub-Signature-256"); len(signature) > 0 {
+		return strings.HasPrefix(signature, "sha256=") && verifyHMAC(body, secret, strings.TrimPrefix(signature, "sha256="))
+	}
+	if signature := header.Get("X-Gitea-Signature"); len(signature) > 0 {
+		return verifyHMAC(body, secret, signature)
+	}
+	if token := header.Get("X-Gitlab-Token"); len(token) > 0 {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	}
+	return false
+}
+
+func verifyHMAC(body []byte, secret string, signatureHex string) bool {
+	signature, err := hex.DecodeString(signatureHex)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
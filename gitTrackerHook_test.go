@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitTrackerHook(t *testing.T) {
+	hookSecret = "secret"
+	tracker := &gitTrackerHook{commits: map[string]string{}}
+	ts := httptest.NewServer(tracker)
+	defer ts.Close()
+
+	sign := func(body string) string {
+		mac := hmac.New(sha256.New, []byte(hookSecret))
+		mac.Write([]byte(body))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	post := func(body string, headers map[string]string) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader(body))
+		require.Nil(t, err)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// no notifications yet
+	_, ok := tracker.GetLastCommit("https://github.com/untillpro/cder", "")
+	require.False(t, ok)
+
+	// GitHub
+	body := `{"ref":"refs/heads/master","after":"1111111111111111111111111111111111111111",
+		"repository":{"clone_url":"https://github.com/untillpro/cder.git","default_branch":"master"}}`
+	require.Equal(t, http.StatusUnauthorized, post(body, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign("wrong")}))
+	require.Equal(t, http.StatusUnauthorized, post(body, map[string]string{"X-GitHub-Event": "push"}))
+	_, ok = tracker.GetLastCommit("https://github.com/untillpro/cder", "")
+	require.False(t, ok)
+	require.Equal(t, http.StatusAccepted, post(body, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign(body)}))
+	lastCommit, ok := tracker.GetLastCommit("https://github.com/untillpro/cder", "")
+	require.True(t, ok)
+	require.Equal(t, "1111111111111111111111111111111111111111", lastCommit)
+
+	// not default branch
+	body = `{"ref":"refs/heads/develop","after":"2222222222222222222222222222222222222222",
+		"repository":{"clone_url":"https://github.com/untillpro/cder.git","default_branch":"master"}}`
+	require.Equal(t, http.StatusAccepted, post(body, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": "sha256=" + sign(body)}))
+	lastCommit, _ = tracker.GetLastCommit("https://github.com/untillpro/cder", "")
+	require.Equal(t, "1111111111111111111111111111111111111111", lastCommit)
+	lastCommit, _ = tracker.GetLastCommit("https://github.com/untillpro/cder#develop", "")
+	require.Equal(t, "2222222222222222222222222222222222222222", lastCommit)
+
+	// Gitea
+	body = `{"ref":"refs/heads/main","after":"3333333333333333333333333333333333333333",
+		"repository":{"clone_url":"https://gitea.example.com/org/repo.git","default_branch":"main"}}`
+	require.Equal(t, http.StatusAccepted, post(body, map[string]string{"X-Gitea-Event": "push", "X-Gitea-Signature": sign(body)}))
+	lastCommit, ok = tracker.GetLastCommit("https://gitea.example.com/org/repo", "")
+	require.True(t, ok)
+	require.Equal(t, "3333333333333333333333333333333333333333", lastCommit)
+
+	// GitLab
+	body = `{"ref":"refs/heads/main","checkout_sha":"4444444444444444444444444444444444444444",
+		"project":{"web_url":"https://gitlab.example.com/group/project","default_branch":"main"}}`
+	require.Equal(t, http.StatusUnauthorized, post(body, map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"}))
+	require.Equal(t, http.StatusAccepted, post(body, map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": hookSecret}))
+	lastCommit, ok = tracker.GetLastCommit("https://gitlab.example.com/group/project.git", "")
+	require.True(t, ok)
+	require.Equal(t, "4444444444444444444444444444444444444444", lastCommit)
+
+	// ping is not a push
+	require.Equal(t, http.StatusNoContent, post(`{}`, map[string]string{"X-GitHub-Event": "ping", "X-Hub-Signature-256": "sha256=" + sign(`{}`)}))
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	gc "github.com/untillpro/gochips"
+)
+
+var useLsRemote bool
+
+// gitTrackerLsRemote asks the remote for the head of the tracked ref using `git ls-remote`.
+// The repo is fetched by `fetcher` only if the head differs from the checked out commit i.e. from the last commit known to watcherGit
+type gitTrackerLsRemote struct {
+	fetcher IGitTracker
+}
+
+func (t *gitTrackerLsRemote) GetLastCommit(repoURL string, repoPath string) (lastCommit string, ok bool) {
+	remoteURL, ref := parseRepoURL(repoURL)
+	if len(ref.commit) > 0 || len(repoPath) == 0 {
+		return t.fetcher.GetLastCommit(repoURL, repoPath)
+	}
+	remoteRef := "HEAD"
+	if len(ref.branch) > 0 {
+		remoteRef = "refs/heads/" + ref.branch
+	} else if len(ref.tag) > 0 {
+		remoteRef = "refs/tags/" + ref.tag
+	}
+	remoteHash, ok := lsRemote(remoteURL, remoteRef)[remoteRef]
+	if !ok {
+		panic("gitTrackerLsRemote: " + remoteRef + " not found at " + remoteURL)
+	}
+	if getHeadCommit(repoPath) == remoteHash {
+		gc.Verbose("gitTrackerLsRemote", "Remote head is not changed", repoURL, remoteHash)
+		return remoteHash, true
+	}
+	gc.Verbose("gitTrackerLsRemote", "Remote head is changed, will be fetched", repoURL, remoteHash)
+	return t.fetcher.GetLastCommit(repoURL, repoPath)
+}
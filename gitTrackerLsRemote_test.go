@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	gc "github.com/untillpro/gochips"
+)
+
+// gitTrackerCounter counts fetches made by the wrapped tracker
+type gitTrackerCounter struct {
+	tracker IGitTracker
+	calls   int
+}
+
+func (t *gitTrackerCounter) GetLastCommit(repoURL string, repoPath string) (string, bool) {
+	t.calls++
+	return t.tracker.GetLastCommit(repoURL, repoPath)
+}
+
+func TestGitTrackerLsRemote(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "cder-lsremote")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	originDir := path.Join(tempDir, "origin")
+	repoDir := path.Join(tempDir, "repo")
+	initTestRepo(t, originDir)
+	first := commitTestRepo(t, originDir, "initial")
+	require.Nil(t, new(gc.PipedExec).Command("git", "clone", "-q", "file://"+originDir, repoDir).Run(os.Stdout, os.Stderr))
+
+	fetcher := &gitTrackerCounter{tracker: &gitTrackerPull{}}
+	tracker := &gitTrackerLsRemote{fetcher: fetcher}
+
+	// remote head is not changed -> not fetched
+	lastCommit, ok := tracker.GetLastCommit("file://"+originDir, repoDir)
+	require.True(t, ok)
+	require.Equal(t, first, lastCommit)
+	require.Equal(t, 0, fetcher.calls)
+
+	// remote head is moved -> fetched
+	second := commitTestRepo(t, originDir, "second")
+	lastCommit, ok = tracker.GetLastCommit("file://"+originDir, repoDir)
+	require.True(t, ok)
+	require.Equal(t, second, lastCommit)
+	require.Equal(t, second, getHeadCommit(repoDir))
+	require.Equal(t, 1, fetcher.calls)
+
+	lastCommit, ok = tracker.GetLastCommit("file://"+originDir, repoDir)
+	require.True(t, ok)
+	require.Equal(t, second, lastCommit)
+	require.Equal(t, 1, fetcher.calls)
+}
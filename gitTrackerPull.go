@@ -1,24 +1,39 @@
 package main
 
 import (
+	"os"
 	"strings"
 
 	gc "github.com/untillpro/gochips"
 )
 
+// gitTrackerPull fetches the tracked ref and resets the working tree to it.
+// Force-pushes and rebases of the tracked branch are followed
 type gitTrackerPull struct {
 }
 
 func (t *gitTrackerPull) GetLastCommit(repoURL string, repoPath string) (lastCommit string, ok bool) {
-	gc.Verbose("watcherGit", "Repo dir exists, will be pulled", repoPath, repoURL)
-	stdouts, stderrs, err := new(gc.PipedExec).
-		Command("git", "pull", repoURL).
-		WorkingDir(repoPath).
-		RunToStrings()
-	if nil != err {
-		gc.Info(stdouts, stderrs)
+	pullURL, ref := parseRepoURL(repoURL)
+	if len(ref.commit) > 0 {
+		gc.Verbose("watcherGit", "Repo is pinned to commit, nothing to pull", repoPath, repoURL)
+	} else {
+		gc.Verbose("watcherGit", "Repo dir exists, will be fetched", repoPath, repoURL)
+		fetchArgs := []string{"fetch", "--force", pullURL}
+		if len(ref.branch) > 0 {
+			fetchArgs = append(fetchArgs, "refs/heads/"+ref.branch)
+		} else if len(ref.tag) > 0 {
+			tagRef := "refs/tags/" + ref.tag
+			fetchArgs = append(fetchArgs, "+"+tagRef+":"+tagRef)
+		}
+		stdouts, stderrs, err := gitCommand(pullURL, fetchArgs...).
+			WorkingDir(repoPath).
+			RunToStrings()
+		if nil != err {
+			gc.Info(stdouts, stderrs)
+		}
+		gc.PanicIfError(err)
+		resetToFetched(repoURL, repoPath)
 	}
-	gc.PanicIfError(err)
 
 	stdout, _, err := new(gc.PipedExec).
 		Command("git", "log", "-n", "1", `--pretty=format:%H`).
@@ -28,3 +43,29 @@ func (t *gitTrackerPull) GetLastCommit(repoURL string, repoPath string) (lastCom
 
 	return strings.TrimSpace(stdout), true
 }
+
+// resetToFetched hard resets the working tree to FETCH_HEAD. Non fast-forward updates are logged
+func resetToFetched(repoURL string, repoPath string) {
+	stdout, _, err := new(gc.PipedExec).
+		Command("git", "rev-parse", "FETCH_HEAD^{commit}").
+		WorkingDir(repoPath).
+		RunToStrings()
+	gc.PanicIfError(err)
+	newHash := strings.TrimSpace(stdout)
+	oldHash := getHeadCommit(repoPath)
+	if newHash == oldHash {
+		return
+	}
+	err = new(gc.PipedExec).
+		Command("git", "merge-base", "--is-ancestor", oldHash, newHash).
+		WorkingDir(repoPath).
+		Run(nil, nil)
+	if err != nil {
+		gc.Info("watcherGit", "Non fast-forward update (force-push or rebase) detected", repoURL, oldHash, newHash)
+	}
+	err = new(gc.PipedExec).
+		Command("git", "reset", "--hard", newHash).
+		WorkingDir(repoPath).
+		Run(os.Stdout, os.Stderr)
+	gc.PanicIfError(err)
+}
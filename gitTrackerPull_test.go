@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	gc "github.com/untillpro/gochips"
+)
+
+func TestGitTrackerPullForcePush(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "cder-pull")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	originDir := path.Join(tempDir, "origin.git")
+	workDir := path.Join(tempDir, "work")
+	repoDir := path.Join(tempDir, "repo")
+	git := func(args ...string) {
+		require.Nil(t, new(gc.PipedExec).Command("git", args...).Run(os.Stdout, os.Stderr))
+	}
+	git("init", "-q", "--bare", originDir)
+	git("clone", "-q", "file://"+originDir, workDir)
+	commitTestRepo(t, workDir, "initial")
+	git("-C", workDir, "push", "-q", "origin", "HEAD")
+	git("clone", "-q", "file://"+originDir, repoDir)
+
+	var output []string
+	defer func(o func(funcName, s string)) { gc.Output = o }(gc.Output)
+	gc.Output = func(funcName, s string) { output = append(output, s) }
+	tracker := &gitTrackerPull{}
+
+	// fast-forward
+	second := commitTestRepo(t, workDir, "second")
+	git("-C", workDir, "push", "-q", "origin", "HEAD")
+	lastCommit, ok := tracker.GetLastCommit("file://"+originDir, repoDir)
+	require.True(t, ok)
+	require.Equal(t, second, lastCommit)
+	require.NotContains(t, strings.Join(output, ""), "Non fast-forward")
+
+	// history is rewritten: the clone is reset to the new head, no panic
+	git("-C", workDir, "-c", "user.name=cder", "-c", "user.email=cder@example.com", "commit", "-q", "--amend", "--allow-empty", "-m", "second amended")
+	amended := getHeadCommit(workDir)
+	require.NotEqual(t, second, amended)
+	git("-C", workDir, "push", "-q", "--force", "origin", "HEAD")
+	lastCommit, ok = tracker.GetLastCommit("file://"+originDir, repoDir)
+	require.True(t, ok)
+	require.Equal(t, amended, lastCommit)
+	require.Equal(t, amended, getHeadCommit(repoDir))
+	require.Contains(t, strings.Join(output, ""), "Non fast-forward")
+}
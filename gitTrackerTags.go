@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	gc "github.com/untillpro/gochips"
+)
+
+var (
+	followTags       string
+	followPrerelease bool
+)
+
+// gitTrackerTags deploys the newest tag which satisfies semver constraints. Branch commits are ignored
+type gitTrackerTags struct {
+	constraints       semConstraints
+	includePrerelease bool
+	// used for repos with explicitly specified revision or without matching tags
+	fallback IGitTracker
+	// repos without matching tags which are already reported
+	untagged map[string]bool
+}
+
+func (t *gitTrackerTags) GetLastCommit(repoURL string, repoPath string) (lastCommit string, ok bool) {
+	tagsURL, ref := parseRepoURL(repoURL)
+	if len(ref.String()) > 0 {
+		return t.fallback.GetLastCommit(repoURL, repoPath)
+	}
+
+	tag, lastCommit := t.findNewestTag(lsRemote(tagsURL, "refs/tags/*"))
+	if len(tag) == 0 {
+		if !t.untagged[tagsURL] {
+			gc.Info("gitTrackerTags:", fmt.Sprintf("no tags of %s match `%s`, branch commits are tracked", tagsURL, followTags))
+			t.untagged[tagsURL] = true
+		}
+		return t.fallback.GetLastCommit(repoURL, repoPath)
+	}
+	delete(t.untagged, tagsURL)
+	gc.Verbose("gitTrackerTags", "Newest matching tag", tagsURL, tag, lastCommit)
+
+	if len(repoPath) > 0 && getHeadCommit(repoPath) != lastCommit {
+		gc.Info("gitTrackerTags:", "Checking out tag "+tag+" at "+repoPath)
+		tagRef := "refs/tags/" + tag
+		stdouts, stderrs, err := gitCommand(tagsURL, "fetch", "--force", tagsURL, "+"+tagRef+":"+tagRef).
+			WorkingDir(repoPath).
+			RunToStrings()
+		if nil != err {
+			gc.Info(stdouts, stderrs)
+		}
+		gc.PanicIfError(err)
+		err = new(gc.PipedExec).
+			Command("git", "reset", "--hard", lastCommit).
+			WorkingDir(repoPath).
+			Run(os.Stdout, os.Stderr)
+		gc.PanicIfError(err)
+	}
+	return lastCommit, true
+}
+
+// findNewestTag returns the greatest version tag which satisfies the constraints
+func (t *gitTrackerTags) findNewestTag(refs map[string]string) (tag string, commit string) {
+	var newest *semVersion
+	for ref, hash := range refs {
+		name := strings.TrimPrefix(ref, "refs/tags/")
+		version, err := parseSemVersion(name)
+		if err != nil || version.parts < 3 {
+			continue
+		}
+		if len(version.pre) > 0 && !t.includePrerelease {
+			continue
+		}
+		if !t.constraints.check(version) {
+			continue
+		}
+		if newest == nil || version.compare(newest) > 0 || (version.compare(newest) == 0 && name > tag) {
+			newest, tag, commit = version, name, hash
+		}
+	}
+	return
+}
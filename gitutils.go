@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	gc "github.com/untillpro/gochips"
+)
+
+// getHeadCommit returns hash of the commit checked out at `repoPath`
+func getHeadCommit(repoPath string) string {
+	stdout, _, err := new(gc.PipedExec).
+		Command("git", "rev-parse", "HEAD").
+		WorkingDir(repoPath).
+		RunToStrings()
+	gc.PanicIfError(err)
+	return strings.TrimSpace(stdout)
+}
+
+// checkoutNotifiedCommit checks out `commit` received from a notification. Wrong commit is reported and refused,
+// so the repo keeps the deployed commit and other repos are not affected
+func checkoutNotifiedCommit(repoURL string, repoPath string, commit string) (lastCommit string, ok bool) {
+	lastCommit = strings.ToLower(commit)
+	if len(repoPath) == 0 {
+		return lastCommit, true
+	}
+	if err := checkoutCommit(repoURL, repoPath, lastCommit); err != nil {
+		gc.Error(err)
+		return "", false
+	}
+	return lastCommit, true
+}
+
+// checkoutCommit fetches `repoURL` and resets the working tree at `repoPath` to `commit`.
+// Error is returned if `commit` is not a hash or not found in the repo
+func checkoutCommit(repoURL string, repoPath string, commit string) error {
+	commit = strings.ToLower(commit)
+	if !commitHashRegexp.MatchString(commit) {
+		return fmt.Errorf("checkoutCommit: `%s` is not a commit hash, %s", commit, repoURL)
+	}
+	if strings.HasPrefix(getHeadCommit(repoPath), commit) {
+		return nil
+	}
+	gc.Verbose("checkoutCommit", "Fetching", repoURL, repoPath)
+	stdouts, stderrs, err := gitCommand(repoURL, "fetch", "--force", "--tags", repoURL, "+refs/heads/*:refs/remotes/origin/*").
+		WorkingDir(repoPath).
+		RunToStrings()
+	if nil != err {
+		gc.Info(stdouts, stderrs)
+	}
+	gc.PanicIfError(err)
+
+	err = new(gc.PipedExec).
+		Command("git", "cat-file", "-e", commit+"^{commit}").
+		WorkingDir(repoPath).
+		Run(nil, nil)
+	if err != nil {
+		return fmt.Errorf("checkoutCommit: commit %s is not found in %s", commit, repoURL)
+	}
+
+	gc.Info("checkoutCommit", "Resetting "+repoPath+" to "+commit)
+	err = new(gc.PipedExec).
+		Command("git", "reset", "--hard", commit).
+		WorkingDir(repoPath).
+		Run(os.Stdout, os.Stderr)
+	gc.PanicIfError(err)
+	return nil
+}
+
+// resolveRepoRevision checks if `@<rev>` of `repoSpec` which looks like a commit hash is a tag of the remote repo.
+// Tag wins, so numeric and hex-looking tags (`@20201231`, `@deadbeef`) are tracked as tags
+func resolveRepoRevision(repoSpec string) {
+	repoURL, ref := parseRepoURL(repoSpec)
+	if len(ref.commit) == 0 {
+		return
+	}
+	rev := repoSpec[strings.LastIndex(repoSpec, "@")+1:]
+	stdouts, stderrs, err := gitCommand(repoURL, "ls-remote", "--tags", repoURL, "refs/tags/"+rev).
+		RunToStrings()
+	if nil != err {
+		gc.Info(stdouts, stderrs)
+		gc.Error("resolveRepoRevision: remote tags are not listed, `@"+rev+"` is considered as commit:", repoURL, err)
+		return
+	}
+	if len(strings.TrimSpace(stdouts)) > 0 {
+		gc.Info("resolveRepoRevision:", "`@"+rev+"` is a tag", repoURL)
+		tagRevisions[repoSpec] = true
+	}
+}
+
+// migrateRepoFolder moves the repo cloned to the legacy <reposFolder>/<name> folder to <host>/<owner>/<name> layout.
+// The legacy folder is moved only if its origin is `repoSpec`, since repos of different owners shared the same folder
+func migrateRepoFolder(repoSpec string) {
+	repoPath, _ := getAbsRepoFolders(repoSpec)
+	legacyPath := getLegacyRepoPath(repoSpec)
+	if len(legacyPath) == 0 || legacyPath == repoPath || !fileExists(path.Join(legacyPath, ".git")) || fileExists(repoPath) {
+		return
+	}
+	repoURL, _ := parseRepoURL(repoSpec)
+	originURL, _, err := new(gc.PipedExec).
+		Command("git", "config", "--get", "remote.origin.url").
+		WorkingDir(legacyPath).
+		RunToStrings()
+	if err != nil || normalizeRepoURL(originURL) != normalizeRepoURL(repoURL) {
+		gc.Verbose("migrateRepoFolder", "Legacy folder belongs to another repo, skipped", legacyPath, strings.TrimSpace(originURL))
+		return
+	}
+	gc.Info("migrateRepoFolder:", "Moving "+legacyPath+" to "+repoPath)
+	gc.PanicIfError(os.MkdirAll(path.Dir(repoPath), 0755))
+	gc.PanicIfError(os.Rename(legacyPath, repoPath))
+	if commit, ok := state.Commits[legacyPath]; ok {
+		delete(state.Commits, legacyPath)
+		state.Commits[repoPath] = commit
+	}
+}
+
+// normalizeRepoURL makes different urls of the same repo comparable:
+// https://github.com/untillpro/cder.git, git@github.com:untillpro/cder -> github.com/untillpro/cder
+func normalizeRepoURL(repoURL string) string {
+	res := strings.TrimSpace(repoURL)
+	if strings.Contains(res, "://") {
+		u, err := url.Parse(res)
+		if err == nil {
+			res = u.Host + u.Path
+		}
+	} else if pos := strings.Index(res, ":"); pos >= 0 {
+		// scp-like syntax: git@github.com:untillpro/cder.git
+		res = res[:pos] + "/" + res[pos+1:]
+		if pos := strings.Index(res, "@"); pos >= 0 {
+			res = res[pos+1:]
+		}
+	}
+	res = strings.TrimSuffix(res, "/")
+	res = strings.TrimSuffix(res, ".git")
+	return strings.ToLower(res)
+}
+
+// lsRemote asks the remote repo for its refs matching `patterns`: ref -> hash
+// Annotated tags are peeled i.e. hash of the tagged commit is returned
+func lsRemote(repoURL string, patterns ...string) map[string]string {
+	stdouts, stderrs, err := gitCommand(repoURL, append([]string{"ls-remote", repoURL}, patterns...)...).
+		RunToStrings()
+	if nil != err {
+		gc.Info(stdouts, stderrs)
+	}
+	gc.PanicIfError(err)
+	res := map[string]string{}
+	peeled := map[string]string{}
+	for _, line := range strings.Split(stdouts, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if strings.HasSuffix(fields[1], "^{}") {
+			peeled[strings.TrimSuffix(fields[1], "^{}")] = fields[0]
+		} else {
+			res[fields[1]] = fields[0]
+		}
+	}
+	for ref, hash := range peeled {
+		res[ref] = hash
+	}
+	return res
+}
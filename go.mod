@@ -3,10 +3,15 @@ module github.com/untillpro/cder
 go 1.17
 
 require (
+	github.com/gorilla/websocket v1.4.2
 	github.com/gotify/go-api-client/v2 v2.0.4
+	github.com/klauspost/compress v1.15.9
 	github.com/spf13/cobra v1.0.0
 	github.com/stretchr/testify v1.5.1
+	github.com/ulikunitz/xz v0.5.10
 	github.com/untillpro/gochips v1.12.0
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
+	gopkg.in/yaml.v2 v2.2.2
 )
 
 require (
@@ -30,7 +35,7 @@ require (
 	github.com/mitchellh/mapstructure v1.1.2 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/spf13/pflag v1.0.3 // indirect
-	golang.org/x/net v0.0.0-20190522155817-f3200d17e092 // indirect
-	golang.org/x/text v0.3.0 // indirect
-	gopkg.in/yaml.v2 v2.2.2 // indirect
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110 // indirect
+	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
+	golang.org/x/text v0.3.3 // indirect
 )
@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/gotify/go-api-client/v2/models"
+)
+
+// gotifyExtrasKey is the key of Gotify message `extras` to take the payload from
+const gotifyExtrasKey = "cder::deploy"
+
+// deployEnvironment is the environment cder deploys to. Payloads for other environments are ignored
+var deployEnvironment string
+
+// deployPayload is what CI announces via Gotify message. Taken from `extras["cder::deploy"]` or from JSON message body.
+// Legacy message which title is the commit hash is also accepted
+type deployPayload struct {
+	Commit      string `json:"commit,omitempty"`
+	Branch      string `json:"branch,omitempty"`
+	ArtifactURL string `json:"artifactURL,omitempty"`
+	DeployerURL string `json:"deployerURL,omitempty"`
+	// sha256 of the artifact, hex. `sha256:` prefix is allowed
+	Checksum string `json:"checksum,omitempty"`
+	// signature of the artifact, see manifestArtifact
+	Signature   string `json:"signature,omitempty"`
+	Environment string `json:"environment,omitempty"`
+}
+
+func parseDeployPayload(msg *models.MessageExternal) *deployPayload {
+	res := &deployPayload{}
+	parsed := false
+	if extra, ok := msg.Extras[gotifyExtrasKey]; ok {
+		extraBytes, err := json.Marshal(extra)
+		parsed = err == nil && json.Unmarshal(extraBytes, res) == nil
+	}
+	if body := strings.TrimSpace(msg.Message); !parsed && strings.HasPrefix(body, "{") {
+		parsed = json.Unmarshal([]byte(body), res) == nil
+	}
+	if !parsed {
+		res = &deployPayload{}
+	}
+	if len(res.Commit) == 0 {
+		res.Commit = strings.TrimSpace(msg.Title)
+	}
+	return res
+}
+
+// accepts returns true if the payload is intended for `repoSpec` at `--environment`
+func (p *deployPayload) accepts(repoSpec string) bool {
+	if len(p.Environment) > 0 && len(deployEnvironment) > 0 && p.Environment != deployEnvironment {
+		return false
+	}
+	if len(p.Branch) > 0 {
+		if _, ref := parseRepoURL(repoSpec); len(ref.branch) > 0 && ref.branch != p.Branch {
+			return false
+		}
+	}
+	return true
+}
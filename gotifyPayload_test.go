@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gotify/go-api-client/v2/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeployPayload(t *testing.T) {
+	// legacy: title is the commit
+	p := parseDeployPayload(&models.MessageExternal{Title: " 1234567 ", Message: "https://example.com/a.zip"})
+	require.Equal(t, &deployPayload{Commit: "1234567"}, p)
+
+	// JSON body
+	p = parseDeployPayload(&models.MessageExternal{Title: "v1.2.0", Message: `{"commit": "abcdef0", "artifactURL": "https://example.com/a.zip", "checksum": "sha256:00ff"}`})
+	require.Equal(t, &deployPayload{Commit: "abcdef0", ArtifactURL: "https://example.com/a.zip", Checksum: "sha256:00ff"}, p)
+
+	// extras has priority over the body
+	p = parseDeployPayload(&models.MessageExternal{
+		Title:   "v1.2.0",
+		Message: `{"commit": "abcdef0"}`,
+		Extras: map[string]interface{}{
+			gotifyExtrasKey: map[string]interface{}{"artifactURL": "https://example.com/b.zip", "branch": "main", "environment": "prod"},
+		},
+	})
+	require.Equal(t, &deployPayload{Commit: "v1.2.0", ArtifactURL: "https://example.com/b.zip", Branch: "main", Environment: "prod"}, p)
+
+	// environment and branch filters
+	defer func() { deployEnvironment = "" }()
+	require.True(t, p.accepts("https://github.com/untillpro/cder"))
+	require.True(t, p.accepts("https://github.com/untillpro/cder#main"))
+	require.False(t, p.accepts("https://github.com/untillpro/cder#dev"))
+	deployEnvironment = "prod"
+	require.True(t, p.accepts("https://github.com/untillpro/cder"))
+	deployEnvironment = "test"
+	require.False(t, p.accepts("https://github.com/untillpro/cder"))
+}
+
+func TestGitTrackerGotifyGetApp(t *testing.T) {
+	var getApps, createApps int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/application" && r.Method == http.MethodGet:
+			getApps++
+			fmt.Fprint(w, `[{"id": 1, "name": "https://github.com/untillpro/cder", "token": "t1"}]`)
+		case r.URL.Path == "/application" && r.Method == http.MethodPost:
+			createApps++
+			fmt.Fprint(w, `{"id": 2, "name": "https://github.com/untillpro/airs-bp", "token": "t2"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	defer func(url string) { gURL = url }(gURL)
+	gURL = ts.URL
+
+	tracker := newGitTrackerGotify()
+	tracker.client = getGotifyClient(ts.URL)
+	require.Equal(t, uint(1), tracker.getApp("https://github.com/untillpro/cder").ID)
+	require.Equal(t, 1, getApps)
+
+	// missing app is created without requesting apps again
+	require.Equal(t, uint(2), tracker.getApp("https://github.com/untillpro/airs-bp").ID)
+	require.Equal(t, 2, getApps)
+	require.Equal(t, 1, createApps)
+	require.Equal(t, uint(2), tracker.getApp("https://github.com/untillpro/airs-bp").ID)
+	require.Equal(t, 2, getApps)
+}
+
+func TestGitTrackerGotifyIteration(t *testing.T) {
+	testWD, err := ioutil.TempDir("", "cder-gotify")
+	require.Nil(t, err)
+	defer os.RemoveAll(testWD)
+	defer func(wd string) { workingDir = wd }(workingDir)
+	workingDir = testWD
+
+	var getMessages int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/application":
+			fmt.Fprint(w, `[{"id": 1, "name": "app1"}, {"id": 2, "name": "app2"}, {"id": 3, "name": "app3"}]`)
+		case "/message":
+			getMessages++
+			fmt.Fprint(w, `{"messages": [{"id": 1, "appid": 1, "title": "1.0", "message": "1.0"}], "paging": {"size": 1, "limit": 1}}`)
+		case "/application/1/message", "/application/2/message", "/application/3/message":
+			fmt.Fprint(w, `{"messages": [], "paging": {"size": 0, "limit": 100}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	defer func(url string) { gURL = url }(gURL)
+	gURL = ts.URL
+
+	// messages of all apps are fetched once per iteration, even if an app is asked twice
+	w := &watcherURL{
+		stored:          map[string]*urlState{},
+		staged:          map[string]*urlState{},
+		manifestTracker: &manifestTrackerGotify{messages: newGitTrackerGotify()},
+	}
+	repos := []string{"app1", "app2", "app3", "app1"}
+	for i := 1; i <= 3; i++ {
+		w.Watch(repos)
+		require.Equal(t, i, getMessages)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	gc "github.com/untillpro/gochips"
+)
+
+// redirect policies, see `--redirects`
+const (
+	redirectsFollow   = "follow"
+	redirectsSameHost = "same-host"
+	redirectsNone     = "none"
+	maxRedirects      = 10
+)
+
+var (
+	redirectPolicy string
+	headerArgs     []string
+	// host -> headers which are sent to it, see `--header`
+	hostHeaders = map[string]http.Header{}
+	// removed from redirected requests, then set again according to the redirect target
+	credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+)
+
+// loadHostHeaders checks `--redirects` and parses `--header <host>=<file>` items. File contains `<Name>: <value>` lines
+func loadHostHeaders() error {
+	switch redirectPolicy {
+	case redirectsFollow, redirectsSameHost, redirectsNone:
+	default:
+		return fmt.Errorf("--redirects must be %s, %s or %s: %s", redirectsFollow, redirectsSameHost, redirectsNone, redirectPolicy)
+	}
+	hostHeaders = map[string]http.Header{}
+	for _, item := range headerArgs {
+		pos := strings.Index(item, "=")
+		if pos < 0 {
+			return fmt.Errorf("--header must be <host>=<file>: %s", item)
+		}
+		host := strings.ToLower(item[:pos])
+		content, err := ioutil.ReadFile(item[pos+1:])
+		if err != nil {
+			return fmt.Errorf("--header %s: %w", host, err)
+		}
+		header, err := textproto.NewReader(bufio.NewReader(strings.NewReader(strings.TrimSpace(string(content)) + "\r\n\r\n"))).ReadMIMEHeader()
+		if err != nil {
+			return fmt.Errorf("--header %s: %w", host, err)
+		}
+		if len(header) == 0 {
+			return fmt.Errorf("--header %s: no headers in %s", host, item[pos+1:])
+		}
+		if hostHeaders[host] == nil {
+			hostHeaders[host] = http.Header{}
+		}
+		for name, values := range header {
+			for _, value := range values {
+				hostHeaders[host].Add(name, value)
+				secrets = append(secrets, value)
+			}
+		}
+		gc.Verbose("loadHostHeaders", "Headers loaded for "+host)
+	}
+	return nil
+}
+
+// authorizeRequest sets `--git-credential`, `--header` and registry token configured for the request url
+func authorizeRequest(req *http.Request) {
+	setHTTPCredential(req)
+	for prefix, token := range registryTokens {
+		if strings.HasPrefix(req.URL.String(), prefix) {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	for name, values := range hostHeaders[strings.ToLower(req.URL.Hostname())] {
+		req.Header[name] = append([]string{}, values...)
+	}
+}
+
+// newHTTPClient returns client which follows redirects according to `--redirects`.
+// Credentials are never sent to another host: they are removed from the redirected request and set according to its url
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			switch redirectPolicy {
+			case redirectsNone:
+				return http.ErrUseLastResponse
+			case redirectsSameHost:
+				if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+					gc.Info("httpClient:", "redirect to another host is not followed, see --redirects", redactURL(req.URL.String()))
+					return http.ErrUseLastResponse
+				}
+			}
+			for _, name := range credentialHeaders {
+				req.Header.Del(name)
+			}
+			for _, headers := range hostHeaders {
+				for name := range headers {
+					req.Header.Del(name)
+				}
+			}
+			authorizeRequest(req)
+			gc.Verbose("httpClient", "Redirected to", redactURL(req.URL.String()))
+			return nil
+		},
+	}
+}
+
+// redactURL removes the query which could contain a signature, e.g. presigned S3 urls
+func redactURL(rawURL string) string {
+	if pos := strings.Index(rawURL, "?"); pos >= 0 {
+		return rawURL[:pos] + "?***"
+	}
+	return rawURL
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPClientRedirects(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "cder-http")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	defer func(policy string, args []string) {
+		redirectPolicy, headerArgs, hostHeaders = policy, args, map[string]http.Header{}
+	}(redirectPolicy, headerArgs)
+
+	var assetHeaders http.Header
+	tsAssets := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assetHeaders = r.Header
+		w.Write([]byte("artifact"))
+	}))
+	defer tsAssets.Close()
+	// another host for the same server
+	assetsURL := strings.Replace(tsAssets.URL, "127.0.0.1", "localhost", 1)
+	var releaseHeaders http.Header
+	tsReleases := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		releaseHeaders = r.Header
+		switch r.URL.Path {
+		case "/same-host":
+			http.Redirect(w, r, "/asset", http.StatusFound)
+		case "/asset":
+			w.Write([]byte("same host artifact"))
+		default:
+			http.Redirect(w, r, assetsURL+"/asset?X-Amz-Signature=secret", http.StatusFound)
+		}
+	}))
+	defer tsReleases.Close()
+
+	headersFile := path.Join(tempDir, "headers")
+	require.Nil(t, ioutil.WriteFile(headersFile, []byte("Authorization: Bearer token\nX-Api-Key: key\n"), 0600))
+	headerArgs = []string{"127.0.0.1=" + headersFile}
+
+	redirectPolicy = "wrong"
+	require.NotNil(t, loadHostHeaders())
+
+	// credentials are not sent to another host
+	redirectPolicy = redirectsFollow
+	require.Nil(t, loadHostHeaders())
+	require.Equal(t, []byte("artifact"), readFromURL(newHTTPClient(), tsReleases.URL+"/release"))
+	require.Equal(t, "Bearer token", releaseHeaders.Get("Authorization"))
+	require.Equal(t, "key", releaseHeaders.Get("X-Api-Key"))
+	require.Empty(t, assetHeaders.Get("Authorization"))
+	require.Empty(t, assetHeaders.Get("X-Api-Key"))
+
+	redirectPolicy = redirectsSameHost
+	require.Nil(t, readFromURL(newHTTPClient(), tsReleases.URL+"/release"))
+	require.Equal(t, []byte("same host artifact"), readFromURL(newHTTPClient(), tsReleases.URL+"/same-host"))
+	require.Equal(t, "key", releaseHeaders.Get("X-Api-Key"))
+
+	redirectPolicy = redirectsNone
+	require.Nil(t, readFromURL(newHTTPClient(), tsReleases.URL+"/same-host"))
+}
@@ -8,10 +8,12 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path"
 	"regexp"
 
+	"github.com/gotify/go-api-client/v2/models"
 	"github.com/spf13/cobra"
 	gc "github.com/untillpro/gochips"
 )
@@ -24,10 +26,11 @@ var (
 	watcher      IWatcher
 	deployer     IDeployer
 	extraRepos   []string
-	argURL       string
+	argURLs      []string
 	replacements map[string]string = map[string]string{}
 	cmdRoot                        = &cobra.Command{
-		Use: "cder watches over provided git repo or artifact and deploys it if changed",
+		Use:               "cder watches over provided git repo or artifact and deploys it if changed",
+		PersistentPreRunE: preRunRoot,
 	}
 	cmdCDURL = &cobra.Command{
 		Use:     "cdurl --url <url to watch over>",
@@ -49,6 +52,26 @@ var (
 		PreRunE: preRunCDGotify,
 		RunE:    runCmdRoot,
 	}
+	cmdCDURLGotify = &cobra.Command{
+		Use:     "cdurlGotify --url <gotify url> --token <gotify token> --app <gotify app>",
+		Short:   "Deploy artifacts announced by Gotify messages. Queries Gotify server each `--timeout` seconds to know if something changed",
+		Long:    "Artifact url, deployer url and checksum are taken from the payload of the last message of <gotify app>. Something changed -> download all, unzip and run deploy.sh at unzipped dir",
+		PreRunE: preRunCDURLGotify,
+		RunE:    runCmdRoot,
+	}
+	cmdCDHook = &cobra.Command{
+		Use:     "cdHook --repo <main-repo> [--extraRepo (<repo1-to-track>|<repo1-from=repo1-to>)[, (<repo2-to-track>|<repo2-from=repo2-to>)]...] --secret <webhook secret> [--listen <address>] [args]",
+		Short:   "Build sources from given git repo. Receives push webhooks from GitHub, Gitea or GitLab to know if something changed",
+		Long:    "Last commit hashes are received as push events at `--listen` address. Signatures are verified using `--secret`. <main-repo> will be build using appropriate deployer (deploy.sh if exists, `go build` otherwise). If main-repo is changed or have changed repo-to-track then main-repo will be build (deploy.sh if exists, golang builder otherwise)",
+		PreRunE: preRunCDHook,
+		RunE:    runCmdRoot,
+	}
+	cmdRollback = &cobra.Command{
+		Use:   "rollback --url <url watched by cdurl> [--to <version>]",
+		Short: "Switch artifacts watched by `cdurl` to the previous kept version or to <version> and run deploy.sh. Network is not used",
+		Long:  "<version> is the version id (dir name at <--working-dir>/artifacts/<url>/versions) or the manifest version. The version rolled back from is not deployed again till the manifest is changed",
+		RunE:  runRollback,
+	}
 	initCmds []string
 )
 
@@ -62,39 +85,95 @@ func execute() error {
 	cmdRoot.PersistentFlags().Int32VarP(&timeoutSec, "timeout", "t", 10, "Seconds between pulls")
 	cmdRoot.PersistentFlags().StringSliceVar(&deployerEnv, "deployer-env", []string{}, "Deployer environment variable")
 	cmdRoot.PersistentFlags().StringSliceVar(&initCmds, "init", []string{}, "Any commands to be executed before start. Could be separated with `;`")
+	cmdRoot.PersistentFlags().StringSliceVar(&gitCredentials, "git-credential", []string{}, "Credential for private repos and artifacts, `<host>[/<owner>]=<file>|env:<VAR>`. Token, `<username>:<token>` or SSH private key")
+	cmdRoot.PersistentFlags().StringVar(&knownHostsFile, "known-hosts", "", "known_hosts file SSH hosts of `--git-credential` keys are verified against. Hosts are verified against ~/.ssh/known_hosts if not specified")
+	cmdRoot.PersistentFlags().StringSliceVar(&trustedKeyArgs, "trusted-key", []string{}, "Minisign or ed25519 public key (base64 or file) artifacts and deployers of `cdurl` must be signed by")
+	cmdRoot.PersistentFlags().StringVar(&redirectPolicy, "redirects", redirectsFollow, "How artifacts and manifests HTTP redirects are handled: follow, same-host or none")
+	cmdRoot.PersistentFlags().StringArrayVar(&headerArgs, "header", []string{}, "Headers sent to the host when downloading artifacts and manifests, `<host>=<file>`. File contains `<Name>: <value>` lines")
+	cmdRoot.PersistentFlags().StringVar(&reportURL, "report-url", "", "Gotify server url to report deploy results to")
+	cmdRoot.PersistentFlags().StringVar(&reportToken, "report-token", "", "Token of Gotify app to report deploy results to")
 	cmdRoot.AddCommand(cmdCDGit)
 	cmdRoot.AddCommand(cmdCDURL)
 	cmdRoot.AddCommand(cmdCDGotify)
+	cmdRoot.AddCommand(cmdCDURLGotify)
+	cmdRoot.AddCommand(cmdCDHook)
+	cmdRoot.AddCommand(cmdRollback)
 
 	cmdCDGit.Flags().StringSliceVar(&extraRepos, "extraRepo", []string{}, "Dependencies of main repository to track for changes")
 	cmdCDGit.Flags().StringVarP(&mainRepo, "repo", "r", "", "Main repository")
 	cmdCDGit.Flags().StringVarP(&binaryName, "output", "o", "", "Output binary name")
 	cmdCDGit.Flags().StringVarP(&buildPath, "build", "b", "", "Path to build at")
+	cmdCDGit.Flags().StringSliceVar(&includePaths, "paths", []string{}, "Globs of files which changes trigger a deploy, `[<repo>=]<glob>`")
+	cmdCDGit.Flags().StringSliceVar(&ignorePaths, "ignore", []string{}, "Globs of files which changes do not trigger a deploy, `[<repo>=]<glob>`")
+	cmdCDGit.Flags().BoolVar(&useLsRemote, "ls-remote", false, "Ask remotes for changes using `git ls-remote`, pull only changed repos")
+	cmdCDGit.Flags().StringVar(&followTags, "follow-tags", "", "Track the newest tag which satisfies semver constraints (e.g. \">=1.4.0 <2.0.0\") instead of branch commits")
+	cmdCDGit.Flags().BoolVar(&followPrerelease, "prerelease", false, "Consider pre-release tags when `--follow-tags` is used")
 	cmdCDGit.MarkFlagRequired("output")
 	cmdCDGit.MarkFlagRequired("repo")
 
-
 	cmdCDGotify.Flags().StringSliceVar(&extraRepos, "extraRepo", []string{}, "Dependencies of main repository to track for changes")
 	cmdCDGotify.Flags().StringVarP(&mainRepo, "repo", "r", "", "Main repository")
 	cmdCDGotify.Flags().StringVarP(&binaryName, "output", "o", "", "Output binary name")
 	cmdCDGotify.Flags().StringVarP(&buildPath, "build", "b", "", "Path to build at")
+	cmdCDGotify.Flags().StringSliceVar(&includePaths, "paths", []string{}, "Globs of files which changes trigger a deploy, `[<repo>=]<glob>`")
+	cmdCDGotify.Flags().StringSliceVar(&ignorePaths, "ignore", []string{}, "Globs of files which changes do not trigger a deploy, `[<repo>=]<glob>`")
 	cmdCDGotify.Flags().StringVarP(&gToken, "token", "", "", "Gotify token")
 	cmdCDGotify.Flags().StringVarP(&gURL, "url", "u", "", "Gotify server url")
+	cmdCDGotify.Flags().BoolVar(&gotifyStream, "stream", false, "Subscribe to Gotify stream instead of polling each `--timeout` seconds")
+	cmdCDGotify.Flags().StringVar(&deployEnvironment, "environment", "", "Ignore messages which payload is intended for another environment")
 	cmdCDGotify.MarkFlagRequired("output")
 	cmdCDGotify.MarkFlagRequired("repo")
 	cmdCDGotify.MarkFlagRequired("app")
 	cmdCDGotify.MarkFlagRequired("token")
 	cmdCDGotify.MarkFlagRequired("url")
 
-	cmdCDURL.Flags().StringVarP(&argURL, "url", "u", "", "URL to download artifact state from")
+	cmdCDHook.Flags().StringSliceVar(&extraRepos, "extraRepo", []string{}, "Dependencies of main repository to track for changes")
+	cmdCDHook.Flags().StringVarP(&mainRepo, "repo", "r", "", "Main repository")
+	cmdCDHook.Flags().StringVarP(&binaryName, "output", "o", "", "Output binary name")
+	cmdCDHook.Flags().StringVarP(&buildPath, "build", "b", "", "Path to build at")
+	cmdCDHook.Flags().StringSliceVar(&includePaths, "paths", []string{}, "Globs of files which changes trigger a deploy, `[<repo>=]<glob>`")
+	cmdCDHook.Flags().StringSliceVar(&ignorePaths, "ignore", []string{}, "Globs of files which changes do not trigger a deploy, `[<repo>=]<glob>`")
+	cmdCDHook.Flags().StringVarP(&hookListen, "listen", "l", ":8080", "Address to receive webhooks at")
+	cmdCDHook.Flags().StringVarP(&hookSecret, "secret", "s", "", "Webhook secret")
+	cmdCDHook.MarkFlagRequired("output")
+	cmdCDHook.MarkFlagRequired("repo")
+	cmdCDHook.MarkFlagRequired("secret")
+
+	cmdCDURL.Flags().StringArrayVarP(&argURLs, "url", "u", []string{}, "URL to download artifact manifest from or `oci://<registry>/<repository>[:<tag>|@<digest>]`. Could be repeated to watch few independent artifacts")
+	cmdCDURL.Flags().IntVar(&keepVersions, "keep-versions", 3, "Count of artifact versions to keep for `rollback`, including the current one")
+	cmdCDURL.Flags().Int64Var(&downloadMaxSize, "download-max-size", 4<<30, "Max size of a downloaded artifact or deployer in bytes, 0 - unlimited")
+	cmdCDURL.Flags().IntVar(&extractMaxEntries, "extract-max-entries", 100000, "Max count of entries of an artifact archive, 0 - unlimited")
+	cmdCDURL.Flags().Int64Var(&extractMaxSize, "extract-max-size", 4<<30, "Max total uncompressed size of an artifact archive in bytes, 0 - unlimited")
+	cmdCDURL.Flags().BoolVar(&extractSymlinks, "extract-symlinks", false, "Extract symlinks of artifact archives which point inside work-dir, skipped otherwise")
+	cmdCDURL.Flags().BoolVar(&ociPlainHTTP, "plain-http", false, "Access OCI registries of `oci://` urls via http instead of https")
 	cmdCDURL.MarkFlagRequired("url")
 
+	cmdRollback.Flags().StringVarP(&rollbackURL, "url", "u", "", "URL watched by `cdurl` or Gotify app watched by `cdurlGotify`")
+	cmdRollback.Flags().StringVar(&rollbackTo, "to", "", "Version id or manifest version to roll back to, previous version if not specified")
+	cmdRollback.MarkFlagRequired("url")
+
+	cmdCDURLGotify.Flags().StringVarP(&gToken, "token", "", "", "Gotify token")
+	cmdCDURLGotify.Flags().StringVarP(&gURL, "url", "u", "", "Gotify server url")
+	cmdCDURLGotify.Flags().StringVarP(&gApp, "app", "a", "", "Gotify app which messages announce artifacts")
+	cmdCDURLGotify.Flags().StringVar(&deployEnvironment, "environment", "", "Ignore messages which payload is intended for another environment")
+	cmdCDURLGotify.Flags().IntVar(&keepVersions, "keep-versions", 3, "Count of artifact versions to keep for `rollback`, including the current one")
+	cmdCDURLGotify.Flags().Int64Var(&downloadMaxSize, "download-max-size", 4<<30, "Max size of a downloaded artifact or deployer in bytes, 0 - unlimited")
+	cmdCDURLGotify.Flags().IntVar(&extractMaxEntries, "extract-max-entries", 100000, "Max count of entries of an artifact archive, 0 - unlimited")
+	cmdCDURLGotify.Flags().Int64Var(&extractMaxSize, "extract-max-size", 4<<30, "Max total uncompressed size of an artifact archive in bytes, 0 - unlimited")
+	cmdCDURLGotify.Flags().BoolVar(&extractSymlinks, "extract-symlinks", false, "Extract symlinks of artifact archives which point inside work-dir, skipped otherwise")
+	cmdCDURLGotify.MarkFlagRequired("token")
+	cmdCDURLGotify.MarkFlagRequired("url")
+	cmdCDURLGotify.MarkFlagRequired("app")
+
+	defer removeTempKeyFiles()
 	return cmdRoot.Execute()
 }
 
 func prepareGitRepos(args []string) {
 	// *************************************************
 	gc.Doing("Calculating parameters")
+	resolveRepoRevision(mainRepo)
+	migrateRepoFolder(mainRepo)
 	re := regexp.MustCompile(`([^=]*)(=(.*))*`)
 	for _, extraRep := range extraRepos {
 		matches := re.FindStringSubmatch(extraRep)
@@ -108,6 +187,8 @@ func prepareGitRepos(args []string) {
 			replacements[matches[1]] = matches[3]
 			repoURLs = append(repoURLs, matches[3])
 		}
+		resolveRepoRevision(repoURLs[len(repoURLs)-1])
+		migrateRepoFolder(repoURLs[len(repoURLs)-1])
 	}
 
 	// *************************************************
@@ -128,10 +209,39 @@ func prepareGitRepos(args []string) {
 	}
 }
 
+func preRunRoot(cmd *cobra.Command, args []string) error {
+	if err := loadCredentials(); err != nil {
+		return err
+	}
+	if err := loadHostHeaders(); err != nil {
+		return err
+	}
+	return loadTrustedKeys()
+}
+
 func preRunCDGit(cmd *cobra.Command, args []string) error {
+	var commitsTracker IGitTracker = &gitTrackerPull{}
+	if useLsRemote {
+		commitsTracker = &gitTrackerLsRemote{
+			fetcher: commitsTracker,
+		}
+	}
+	if len(followTags) > 0 {
+		constraints, err := parseSemConstraints(followTags)
+		if err != nil {
+			return err
+		}
+		commitsTracker = &gitTrackerTags{
+			constraints:       constraints,
+			includePrerelease: followPrerelease,
+			fallback:          commitsTracker,
+			untagged:          map[string]bool{},
+		}
+	}
+	loadState()
 	watcher = &watcherGit{
-		lastCommitHashes: map[string]string{},
-		commitsTracker: &gitTrackerPull{},
+		lastCommitHashes: state.Commits,
+		commitsTracker:   commitsTracker,
 	}
 	repoURLs = []string{mainRepo}
 	prepareGitRepos(args)
@@ -139,17 +249,71 @@ func preRunCDGit(cmd *cobra.Command, args []string) error {
 }
 
 func preRunCmdURL(cmd *cobra.Command, args []string) error {
-	watcher = &watcherURL{}
-	repoURLs = []string{argURL}
+	artifactHomePaths := map[string]string{}
+	for _, url := range argURLs {
+		if other, ok := artifactHomePaths[getArtifactHomePath(url)]; ok {
+			return fmt.Errorf("--url %s: artifacts dir is the same as for --url %s", url, other)
+		}
+		artifactHomePaths[getArtifactHomePath(url)] = url
+		if !isOCIReference(url) {
+			continue
+		}
+		if _, err := parseOCIReference(url); err != nil {
+			return fmt.Errorf("--url: %w", err)
+		}
+	}
+	loadState()
+	watcher = &watcherURL{
+		stored:          state.URLs,
+		staged:          map[string]*urlState{},
+		manifestTracker: newManifestTrackerURL(),
+	}
+	deployer = newDeployer4urls(argURLs)
+	deployIndependently = true
+	repoURLs = argURLs
 	return nil
 }
 
 func preRunCDGotify(cmd *cobra.Command, args []string) error {
+	var commitsTracker IGitTracker = newGitTrackerGotify()
+	if gotifyStream {
+		commitsTracker = &gitTrackerGotifyStream{
+			apps:         map[string]uint{},
+			lastMessages: map[uint]*models.MessageExternal{},
+		}
+	}
+	loadState()
 	watcher = &watcherGit{
-		lastCommitHashes: map[string]string{},
-		commitsTracker: &gitTrackerGotify{},
+		lastCommitHashes: state.Commits,
+		commitsTracker:   commitsTracker,
 	}
 	repoURLs = []string{mainRepo}
 	prepareGitRepos(args)
 	return nil
 }
+
+func preRunCDURLGotify(cmd *cobra.Command, args []string) error {
+	loadState()
+	watcher = &watcherURL{
+		stored:          state.URLs,
+		staged:          map[string]*urlState{},
+		manifestTracker: &manifestTrackerGotify{messages: newGitTrackerGotify()},
+	}
+	deployer = newDeployer4sh(gApp)
+	repoURLs = []string{gApp}
+	return nil
+}
+
+func preRunCDHook(cmd *cobra.Command, args []string) error {
+	commitsTracker := &gitTrackerHook{
+		commits: map[string]string{},
+	}
+	loadState()
+	watcher = &watcherGit{
+		lastCommitHashes: state.Commits,
+		commitsTracker:   commitsTracker,
+	}
+	repoURLs = []string{mainRepo}
+	prepareGitRepos(args)
+	return commitsTracker.listen(hookListen)
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const manifestFormatVersion = 2
+
+// artifactManifest is what should be deployed by watcherURL. Published at `cdurl --url` as JSON or YAML:
+//
+//	formatVersion: 2
+//	version: 1.2.0
+//	artifacts:
+//	  - url: https://example.com/app-1.2.0.zip
+//	    sha256: 9f86d08...
+//	deployerURL: https://example.com/deploy.sh
+//	deployerSHA256: 60303ae...
+//	env:
+//	  PORT: "8080"
+//	args: [--verbose]
+//
+// Legacy format is a plain text: 1st line - artifact url, 2nd line - deployer url
+type artifactManifest struct {
+	FormatVersion int                `json:"formatVersion" yaml:"formatVersion"`
+	Version       string             `json:"version,omitempty" yaml:"version,omitempty"`
+	Artifacts     []manifestArtifact `json:"artifacts" yaml:"artifacts"`
+	// empty -> deploy.sh from the artifacts is used
+	DeployerURL       string `json:"deployerURL,omitempty" yaml:"deployerURL,omitempty"`
+	DeployerSHA256    string `json:"deployerSHA256,omitempty" yaml:"deployerSHA256,omitempty"`
+	DeployerSignature string `json:"deployerSignature,omitempty" yaml:"deployerSignature,omitempty"`
+	// environment variables for deploy.sh
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	// passed to deploy.sh as `CDER_ARGS` environment variable
+	Args []string `json:"args,omitempty" yaml:"args,omitempty"`
+	// artifacts are addressed by digests (OCI layers), `--trusted-key` signatures are not required
+	digestVerified bool
+}
+
+type manifestArtifact struct {
+	URL string `json:"url" yaml:"url"`
+	// file name to save the artifact as, the last part of the url path if empty
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// hex, `sha256:` prefix is allowed. Not checked if empty
+	SHA256 string `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	// zip, tar, tar.gz, tar.xz, tar.zst or raw (single file, e.g. executable). Detected if empty
+	Format string `json:"format,omitempty" yaml:"format,omitempty"`
+	// expected size in bytes, bigger or smaller download is refused. Not checked if 0
+	Size int64 `json:"size,omitempty" yaml:"size,omitempty"`
+	// minisign signature or base64 ed25519 signature. Empty -> `<url>.minisig` is used if `--trusted-key` is specified
+	Signature string `json:"signature,omitempty" yaml:"signature,omitempty"`
+}
+
+// parseManifest parses JSON or YAML manifest. Content which is neither is considered as legacy two lines manifest
+func parseManifest(content []byte) (*artifactManifest, error) {
+	res := &artifactManifest{}
+	trimmed := strings.TrimSpace(string(content))
+	var err error
+	if strings.HasPrefix(trimmed, "{") {
+		err = json.Unmarshal([]byte(trimmed), res)
+	} else {
+		err = yaml.Unmarshal([]byte(trimmed), res)
+	}
+	if err != nil || res.FormatVersion == 0 {
+		if strings.HasPrefix(trimmed, "{") {
+			return nil, fmt.Errorf("invalid manifest: %v", err)
+		}
+		return parseLegacyManifest(trimmed)
+	}
+	if res.FormatVersion > manifestFormatVersion {
+		return nil, fmt.Errorf("manifest formatVersion %d is not supported, max %d", res.FormatVersion, manifestFormatVersion)
+	}
+	if len(res.Artifacts) == 0 {
+		return nil, errors.New("invalid manifest: no artifacts")
+	}
+	for _, artifact := range res.Artifacts {
+		if len(artifact.URL) == 0 {
+			return nil, errors.New("invalid manifest: artifact url is empty")
+		}
+		if len(artifact.Name) > 0 && (path.Base(artifact.Name) != artifact.Name || artifact.Name == "..") {
+			return nil, fmt.Errorf("invalid manifest: artifact name must be a file name: %s", artifact.Name)
+		}
+	}
+	return res, nil
+}
+
+func parseLegacyManifest(content string) (*artifactManifest, error) {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 || len(lines) > 2 {
+		return nil, fmt.Errorf("invalid manifest: 1 or 2 lines expected, got %d", len(lines))
+	}
+	res := &artifactManifest{
+		Artifacts: []manifestArtifact{{URL: lines[0]}},
+	}
+	if len(lines) > 1 {
+		res.DeployerURL = lines[1]
+	}
+	return res, nil
+}
+
+// fileName returns the name to save the artifact as: `name` or the last part of the url path
+func (a *manifestArtifact) fileName() string {
+	if len(a.Name) > 0 {
+		return a.Name
+	}
+	_, res := parseArtifactURL(a.URL)
+	return res
+}
+
+// artifactsKey is stored to know if artifacts are changed. Equals to the artifact url for the single artifact without checksum
+func (m *artifactManifest) artifactsKey() string {
+	var parts []string
+	for _, artifact := range m.Artifacts {
+		part := artifact.URL
+		if len(artifact.SHA256) > 0 {
+			part += "#" + artifact.SHA256
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// versionID identifies artifacts and deployer of the manifest: `<version>-<hash>`, hash only if version is not specified
+func (m *artifactManifest) versionID() string {
+	hash := sha256.Sum256([]byte(m.artifactsKey() + "\n" + m.DeployerURL))
+	hashStr := hex.EncodeToString(hash[:])
+	if version := regexp.MustCompile("[^a-zA-Z0-9._-]+").ReplaceAllString(m.Version, "_"); len(version) > 0 {
+		return version + "-" + hashStr[:8]
+	}
+	return hashStr[:12]
+}
+
+// deployEnv returns `env` as sorted <name>=<value> list
+func (m *artifactManifest) deployEnv() []string {
+	var res []string
+	for name, value := range m.Env {
+		res = append(res, name+"="+value)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// verifyChecksum panics if sha256 `hash` is not `checksum`
+func verifyChecksum(hash []byte, checksum string) {
+	expected := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(checksum), "sha256:"))
+	if actual := hex.EncodeToString(hash); actual != expected {
+		panic(fmt.Sprintf("checksum mismatch: expected sha256 %s, got %s", expected, actual))
+	}
+}
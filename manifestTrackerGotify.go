@@ -0,0 +1,42 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	gc "github.com/untillpro/gochips"
+)
+
+// gApp is the name of Gotify app which messages announce artifacts
+var gApp string
+
+// manifestTrackerGotify takes manifest from the payload of the newest message of Gotify app named `repo`
+type manifestTrackerGotify struct {
+	messages *gitTrackerGotify
+}
+
+func (t *manifestTrackerGotify) StartIteration() {
+	t.messages.StartIteration()
+}
+
+func (t *manifestTrackerGotify) GetManifest(repo string) (manifest *artifactManifest, ok bool) {
+	lastMessage := t.messages.getLastMessage(repo)
+	if lastMessage == nil {
+		return nil, false
+	}
+	payload := parseDeployPayload(lastMessage)
+	if len(payload.ArtifactURL) == 0 {
+		gc.Info("manifestTrackerGotify:", "Last message has no artifactURL, ignored", lastMessage.ID, lastMessage.Title)
+		return nil, false
+	}
+	return &artifactManifest{
+		FormatVersion: manifestFormatVersion,
+		Version:       lastMessage.Title,
+		Artifacts:     []manifestArtifact{{URL: payload.ArtifactURL, SHA256: payload.Checksum, Signature: payload.Signature}},
+		DeployerURL:   payload.DeployerURL,
+	}, true
+}
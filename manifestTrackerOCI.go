@@ -0,0 +1,295 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	gc "github.com/untillpro/gochips"
+)
+
+const (
+	ociScheme               = "oci://"
+	ociMediaTypeManifest    = "application/vnd.oci.image.manifest.v1+json"
+	ociMediaTypeArtifact    = "application/vnd.oci.artifact.manifest.v1+json"
+	dockerMediaTypeManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	ociAnnotationTitle      = "org.opencontainers.image.title"
+	// set by `oras push` for directories which are packed to tar.gz
+	orasAnnotationUnpack      = "io.deis.oras.content.unpack"
+	ociAnnotationVersion      = "org.opencontainers.image.version"
+	ociDigestHeader           = "Docker-Content-Digest"
+	ociDefaultTag             = "latest"
+	ociDefaultAuthScopeAction = "pull"
+)
+
+var (
+	// registries are accessed via http instead of https, see `--plain-http`
+	ociPlainHTTP bool
+	// `<scheme>://<registry>/v2/<repository>/` -> bearer token received from the registry auth server
+	registryTokens          = map[string]string{}
+	authChallengeParamRegex = regexp.MustCompile(`(\w+)="([^"]*)"`)
+	// layer media type -> artifact format. `application/vnd.oci.image.layer.v1.tar` is not here:
+	// `oras push` uses it for plain files by default, so the format is detected by content
+	ociLayerFormats = map[string]string{
+		"application/vnd.oci.image.layer.v1.tar+gzip":                  formatTarGz,
+		"application/vnd.oci.image.layer.v1.tar+zstd":                  formatTarZst,
+		"application/vnd.docker.image.rootfs.diff.tar.gzip":            formatTarGz,
+		"application/vnd.oci.image.layer.nondistributable.v1.tar+gzip": formatTarGz,
+	}
+)
+
+// ociReference is `oci://<registry>/<repository>[:<tag>|@<digest>]`
+type ociReference struct {
+	registry   string
+	repository string
+	// tag or digest
+	reference string
+}
+
+// ociManifest is OCI image manifest, OCI artifact manifest or Docker image manifest v2
+type ociManifest struct {
+	MediaType   string            `json:"mediaType"`
+	Layers      []ociDescriptor   `json:"layers"`
+	Blobs       []ociDescriptor   `json:"blobs"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// manifestTrackerOCI tracks OCI artifact by its digest using registry HTTP API v2, e.g. pushed by `oras push`.
+// Each layer is the artifact, file name is taken from `org.opencontainers.image.title` annotation
+type manifestTrackerOCI struct {
+	// watched reference -> digest and manifest of the last manifest
+	digests   map[string]string
+	manifests map[string]*artifactManifest
+}
+
+func newManifestTrackerOCI() *manifestTrackerOCI {
+	return &manifestTrackerOCI{
+		digests:   map[string]string{},
+		manifests: map[string]*artifactManifest{},
+	}
+}
+
+func isOCIReference(repo string) bool {
+	return strings.HasPrefix(repo, ociScheme)
+}
+
+func parseOCIReference(repo string) (*ociReference, error) {
+	s := strings.TrimPrefix(repo, ociScheme)
+	pos := strings.Index(s, "/")
+	if pos <= 0 || pos == len(s)-1 {
+		return nil, fmt.Errorf("%s<registry>/<repository>[:<tag>|@<digest>] expected: %s", ociScheme, repo)
+	}
+	res := &ociReference{registry: s[:pos], repository: s[pos+1:], reference: ociDefaultTag}
+	if pos := strings.Index(res.repository, "@"); pos >= 0 {
+		res.repository, res.reference = res.repository[:pos], res.repository[pos+1:]
+	} else if pos := strings.LastIndex(res.repository, ":"); pos > strings.LastIndex(res.repository, "/") {
+		res.repository, res.reference = res.repository[:pos], res.repository[pos+1:]
+	}
+	if len(res.repository) == 0 || len(res.reference) == 0 {
+		return nil, fmt.Errorf("%s<registry>/<repository>[:<tag>|@<digest>] expected: %s", ociScheme, repo)
+	}
+	return res, nil
+}
+
+// baseURL returns `<scheme>://<registry>/v2/<repository>`
+func (r *ociReference) baseURL() string {
+	scheme := "https"
+	if ociPlainHTTP {
+		scheme = "http"
+	}
+	return scheme + "://" + r.registry + "/v2/" + r.repository
+}
+
+func (r *ociReference) isDigest() bool {
+	return strings.Contains(r.reference, ":")
+}
+
+func (t *manifestTrackerOCI) GetManifest(repo string) (manifest *artifactManifest, ok bool) {
+	ref, err := parseOCIReference(repo)
+	if err != nil {
+		gc.Error("manifestTrackerOCI:", err)
+		return nil, false
+	}
+	client := newHTTPClient()
+	manifestURL := ref.baseURL() + "/manifests/" + ref.reference
+
+	// digest is checked first, so unchanged manifest is not downloaded
+	resp := ociRequest(client, http.MethodHead, manifestURL, ref)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		gc.Info("manifestTrackerOCI:", fmt.Sprintf("response: %d", resp.StatusCode), repo)
+		return nil, false
+	}
+	digest := resp.Header.Get(ociDigestHeader)
+	if cached, ok := t.manifests[repo]; ok && len(digest) > 0 && digest == t.digests[repo] {
+		gc.Verbose("manifestTrackerOCI", "Digest is not changed", repo, digest)
+		return cached, true
+	}
+
+	resp = ociRequest(client, http.MethodGet, manifestURL, ref)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		gc.Info("manifestTrackerOCI:", fmt.Sprintf("response: %d", resp.StatusCode), repo)
+		return nil, false
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	gc.PanicIfError(err)
+	// the tag could be moved since HEAD request
+	digest = resp.Header.Get(ociDigestHeader)
+	hash := sha256.Sum256(body)
+	actual := "sha256:" + hex.EncodeToString(hash[:])
+	if len(digest) == 0 {
+		digest = actual
+	}
+	if strings.HasPrefix(digest, "sha256:") && digest != actual || ref.isDigest() && digest != ref.reference {
+		gc.Error("manifestTrackerOCI:", repo, "manifest digest mismatch:", digest, actual)
+		return nil, false
+	}
+	manifest, err = parseOCIManifest(body, ref)
+	if err != nil {
+		gc.Error("manifestTrackerOCI:", repo, err)
+		return nil, false
+	}
+	gc.Info("manifestTrackerOCI:", "manifest changed", repo, digest)
+	t.digests[repo] = digest
+	t.manifests[repo] = manifest
+	return manifest, true
+}
+
+// parseOCIManifest converts OCI manifest to artifactManifest which artifacts are the layers
+func parseOCIManifest(content []byte, ref *ociReference) (*artifactManifest, error) {
+	m := &ociManifest{}
+	if err := json.Unmarshal(content, m); err != nil {
+		return nil, fmt.Errorf("invalid OCI manifest: %w", err)
+	}
+	layers := m.Layers
+	if m.MediaType == ociMediaTypeArtifact {
+		layers = m.Blobs
+	}
+	if len(layers) == 0 {
+		return nil, errors.New("invalid OCI manifest: no layers, image index is not supported")
+	}
+	res := &artifactManifest{
+		FormatVersion:  manifestFormatVersion,
+		Version:        m.Annotations[ociAnnotationVersion],
+		digestVerified: true,
+	}
+	if len(res.Version) == 0 && !ref.isDigest() {
+		res.Version = ref.reference
+	}
+	for _, layer := range layers {
+		pos := strings.Index(layer.Digest, ":")
+		if pos <= 0 {
+			return nil, fmt.Errorf("invalid OCI manifest: layer digest %q", layer.Digest)
+		}
+		algorithm, hexDigest := layer.Digest[:pos], layer.Digest[pos+1:]
+		if algorithm != "sha256" {
+			return nil, fmt.Errorf("invalid OCI manifest: layer digest algorithm %s is not supported", algorithm)
+		}
+		artifact := manifestArtifact{
+			URL:    ref.baseURL() + "/blobs/" + layer.Digest,
+			Name:   path.Base(layer.Annotations[ociAnnotationTitle]),
+			SHA256: hexDigest,
+			Format: ociLayerFormats[layer.MediaType],
+			Size:   layer.Size,
+		}
+		if len(artifact.Format) == 0 && layer.Annotations[orasAnnotationUnpack] == "true" {
+			artifact.Format = formatTarGz
+		}
+		if artifact.Name == "." || artifact.Name == "/" || artifact.Name == ".." {
+			artifact.Name = hexDigest
+		}
+		res.Artifacts = append(res.Artifacts, artifact)
+	}
+	return res, nil
+}
+
+// ociRequest requests registry API. Bearer token is requested from the registry auth server on 401 and the request is repeated
+func ociRequest(client *http.Client, method string, rawURL string, ref *ociReference) *http.Response {
+	doRequest := func() *http.Response {
+		req, err := http.NewRequest(method, rawURL, nil)
+		gc.PanicIfError(err)
+		req.Header.Set("Accept", strings.Join([]string{ociMediaTypeManifest, ociMediaTypeArtifact, dockerMediaTypeManifest}, ", "))
+		authorizeRequest(req)
+		resp, err := client.Do(req)
+		gc.PanicIfError(err)
+		return resp
+	}
+	resp := doRequest()
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if resp.StatusCode != http.StatusUnauthorized || !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return resp
+	}
+	resp.Body.Close()
+	requestRegistryToken(client, challenge, ref)
+	return doRequest()
+}
+
+// requestRegistryToken gets bearer token from the auth server specified by `WWW-Authenticate` challenge.
+// `--git-credential` configured for the registry is used to authenticate
+func requestRegistryToken(client *http.Client, challenge string, ref *ociReference) {
+	params := map[string]string{}
+	for _, match := range authChallengeParamRegex.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || len(realm.Host) == 0 {
+		panic("manifestTrackerOCI: invalid auth challenge: " + challenge)
+	}
+	query := realm.Query()
+	if service, ok := params["service"]; ok {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if len(scope) == 0 {
+		scope = "repository:" + ref.repository + ":" + ociDefaultAuthScopeAction
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	gc.PanicIfError(err)
+	setRequestCredential(req, findCredential("https://"+ref.registry+"/"+ref.repository))
+	resp, err := client.Do(req)
+	gc.PanicIfError(err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		panic(fmt.Sprintf("manifestTrackerOCI: token is not received from %s: %d", realm.Host, resp.StatusCode))
+	}
+	tokenResp := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	gc.PanicIfError(json.NewDecoder(resp.Body).Decode(&tokenResp))
+	token := tokenResp.Token
+	if len(token) == 0 {
+		token = tokenResp.AccessToken
+	}
+	if len(token) == 0 {
+		panic("manifestTrackerOCI: empty token is received from " + realm.Host)
+	}
+	registryTokens[ref.baseURL()+"/"] = token
+	gc.Verbose("manifestTrackerOCI", "Token received for", ref.registry+"/"+ref.repository)
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestTrackerOCI(t *testing.T) {
+	testWD, err := ioutil.TempDir("", "cder-oci")
+	require.Nil(t, err)
+	defer os.RemoveAll(testWD)
+	defer func(wd string) { workingDir, ociPlainHTTP, registryTokens = wd, false, map[string]string{} }(workingDir)
+	workingDir = testWD
+	ociPlainHTTP = true
+
+	// registry stub: layers are pushed as `oras push <registry>/app/cder:1.0 deploy.sh ./dist`
+	deployer := []byte("#!/bin/sh\necho deployed\n")
+	deployerHash := sha256.Sum256(deployer)
+	deployerDigest := "sha256:" + hex.EncodeToString(deployerHash[:])
+	distBuf := new(bytes.Buffer)
+	gzipWriter := gzip.NewWriter(distBuf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	require.Nil(t, tarWriter.WriteHeader(&tar.Header{Name: "dist/app.txt", Mode: 0644, Size: 5, Typeflag: tar.TypeReg}))
+	_, err = tarWriter.Write([]byte("hello"))
+	require.Nil(t, err)
+	require.Nil(t, tarWriter.Close())
+	require.Nil(t, gzipWriter.Close())
+	dist := distBuf.Bytes()
+	distHash := sha256.Sum256(dist)
+	distDigest := "sha256:" + hex.EncodeToString(distHash[:])
+	manifest := fmt.Sprintf(`{"schemaVersion": 2, "mediaType": "%s", "layers": [
+		{"mediaType": "application/vnd.oci.image.layer.v1.tar", "digest": "%s", "size": %d,
+		"annotations": {"%s": "deploy.sh"}},
+		{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": "%s", "size": %d,
+		"annotations": {"%s": "dist", "%s": "true"}}]}`,
+		ociMediaTypeManifest, deployerDigest, len(deployer), ociAnnotationTitle, distDigest, len(dist), ociAnnotationTitle, orasAnnotationUnpack)
+	manifestHash := sha256.Sum256([]byte(manifest))
+	manifestDigest := "sha256:" + hex.EncodeToString(manifestHash[:])
+	var tokenRequests, manifestGets int
+	servedDigest := manifestDigest
+	var registry *httptest.Server
+	registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			tokenRequests++
+			require.Equal(t, "repository:app/cder:pull", r.URL.Query().Get("scope"))
+			fmt.Fprint(w, `{"token": "secret-token"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:app/cder:pull"`, registry.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/app/cder/manifests/1.0":
+			require.Contains(t, r.Header.Get("Accept"), ociMediaTypeManifest)
+			w.Header().Set("Content-Type", ociMediaTypeManifest)
+			w.Header().Set(ociDigestHeader, servedDigest)
+			if r.Method == http.MethodGet {
+				manifestGets++
+				fmt.Fprint(w, manifest)
+			}
+		case "/v2/app/cder/blobs/" + deployerDigest:
+			w.Write(deployer)
+		case "/v2/app/cder/blobs/" + distDigest:
+			w.Write(dist)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer registry.Close()
+	repo := ociScheme + strings.TrimPrefix(registry.URL, "http://") + "/app/cder:1.0"
+
+	ref, err := parseOCIReference(repo)
+	require.Nil(t, err)
+	require.Equal(t, "app/cder", ref.repository)
+	require.Equal(t, "1.0", ref.reference)
+	ref, err = parseOCIReference("oci://localhost:5000/app@" + manifestDigest)
+	require.Nil(t, err)
+	require.Equal(t, "localhost:5000", ref.registry)
+	require.Equal(t, manifestDigest, ref.reference)
+	ref, err = parseOCIReference("oci://localhost:5000/app")
+	require.Nil(t, err)
+	require.Equal(t, ociDefaultTag, ref.reference)
+	_, err = parseOCIReference("oci://localhost:5000")
+	require.NotNil(t, err)
+
+	tracker := newManifestTrackerOCI()
+	m, ok := tracker.GetManifest(repo)
+	require.True(t, ok)
+	require.Equal(t, "1.0", m.Version)
+	require.Len(t, m.Artifacts, 2)
+	require.Equal(t, "deploy.sh", m.Artifacts[0].Name)
+	require.Equal(t, hex.EncodeToString(deployerHash[:]), m.Artifacts[0].SHA256)
+	require.Equal(t, int64(len(deployer)), m.Artifacts[0].Size)
+	require.Empty(t, m.Artifacts[0].Format)
+	require.Equal(t, "dist", m.Artifacts[1].Name)
+	require.Equal(t, formatTarGz, m.Artifacts[1].Format)
+	require.Equal(t, 1, tokenRequests)
+	// the current registry token is hidden in the output, refreshed tokens do not pile up in secrets
+	require.Equal(t, "token ***", redactSecrets("token secret-token"))
+	require.NotContains(t, secrets, "secret-token")
+
+	// unchanged digest -> manifest is not downloaded again
+	cached, ok := tracker.GetManifest(repo)
+	require.True(t, ok)
+	require.Equal(t, m, cached)
+	require.Equal(t, 1, manifestGets)
+
+	// digest which does not match the content is refused
+	servedDigest = "sha256:" + hex.EncodeToString(deployerHash[:])
+	_, ok = newManifestTrackerOCI().GetManifest(repo)
+	require.False(t, ok)
+	servedDigest = manifestDigest
+
+	// layers are downloaded with the registry token and deploy.sh is taken from them.
+	// Layers are verified by digest, so signatures of `--trusted-key` are not required
+	defer func() { trustedKeys = nil }()
+	trustedKeys = []*trustedKey{{}}
+	w := &watcherURL{
+		stored:          map[string]*urlState{},
+		staged:          map[string]*urlState{},
+		manifestTracker: newManifestTrackerURL(),
+	}
+	require.Equal(t, getCurrentVersionPath(repo), w.watch(repo))
+	w.Commit([]string{getCurrentVersionPath(repo)})
+	actual, err := ioutil.ReadFile(path.Join(getCurrentVersionPath(repo), "deploy.sh"))
+	require.Nil(t, err)
+	require.Equal(t, deployer, actual)
+	actual, err = ioutil.ReadFile(path.Join(getCurrentVersionPath(repo), "dist", "app.txt"))
+	require.Nil(t, err)
+	require.Equal(t, "hello", string(actual))
+	require.Empty(t, w.watch(repo))
+	require.Equal(t, 1, tokenRequests)
+}
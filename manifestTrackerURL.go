@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	gc "github.com/untillpro/gochips"
+)
+
+// max delay between manifest requests when the server responds with 429 or 503
+const manifestMaxBackoff = 10 * time.Minute
+
+// manifestTrackerURL reads manifest from the watched url, see artifactManifest.
+// Conditional requests are used, so unchanged manifest is neither downloaded nor parsed.
+// `oci://` urls are tracked by manifestTrackerOCI
+type manifestTrackerURL struct {
+	polls map[string]*manifestPoll
+	oci   *manifestTrackerOCI
+}
+
+// manifestPoll is the last response for the watched url
+type manifestPoll struct {
+	etag         string
+	lastModified string
+	body         []byte
+	manifest     *artifactManifest
+	// url is not requested before, see Cache-Control and Retry-After
+	nextPoll time.Time
+	backoff  time.Duration
+}
+
+func newManifestTrackerURL() *manifestTrackerURL {
+	return &manifestTrackerURL{polls: map[string]*manifestPoll{}, oci: newManifestTrackerOCI()}
+}
+
+func (t *manifestTrackerURL) GetManifest(repo string) (manifest *artifactManifest, ok bool) {
+	if isOCIReference(repo) {
+		return t.oci.GetManifest(repo)
+	}
+	poll, ok := t.polls[repo]
+	if !ok {
+		poll = &manifestPoll{}
+		t.polls[repo] = poll
+	}
+	if time.Now().Before(poll.nextPoll) {
+		gc.Verbose("manifestTrackerURL", "Request is postponed till", poll.nextPoll.Format(time.RFC3339), repo)
+		return poll.manifest, poll.manifest != nil
+	}
+
+	client := newHTTPClient()
+	req, err := http.NewRequest(http.MethodGet, repo, nil)
+	gc.PanicIfError(err)
+	authorizeRequest(req)
+	if poll.manifest != nil {
+		if len(poll.etag) > 0 {
+			req.Header.Set("If-None-Match", poll.etag)
+		}
+		if len(poll.lastModified) > 0 {
+			req.Header.Set("If-Modified-Since", poll.lastModified)
+		}
+	}
+	resp, err := client.Do(req)
+	gc.PanicIfError(err)
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		gc.Verbose("manifestTrackerURL", "Not modified", repo)
+		poll.backoff = 0
+		poll.nextPoll = cacheExpiration(resp)
+		return poll.manifest, poll.manifest != nil
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		poll.postpone(resp)
+		gc.Info("manifestTrackerURL:", fmt.Sprintf("response: %d, next request at %s", resp.StatusCode, poll.nextPoll.Format(time.RFC3339)), repo)
+		return nil, false
+	default:
+		gc.Info("manifestTrackerURL:", fmt.Sprintf("response: %d", resp.StatusCode), repo)
+		return nil, false
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	gc.PanicIfError(err)
+	poll.backoff = 0
+	poll.nextPoll = cacheExpiration(resp)
+	poll.etag = resp.Header.Get("ETag")
+	poll.lastModified = resp.Header.Get("Last-Modified")
+	if poll.manifest != nil && bytes.Equal(body, poll.body) {
+		return poll.manifest, true
+	}
+	manifest, err = parseManifest(body)
+	if err != nil {
+		gc.Error("manifestTrackerURL:", repo, err)
+		poll.manifest, poll.body = nil, nil
+		return nil, false
+	}
+	poll.manifest, poll.body = manifest, body
+	return manifest, true
+}
+
+// postpone next request according to Retry-After, up to manifestMaxBackoff. Not specified -> delay is doubled each time, starting from `--timeout`
+func (p *manifestPoll) postpone(resp *http.Response) {
+	if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		if retryAfter > manifestMaxBackoff {
+			gc.Info("manifestTrackerURL:", "Retry-After", retryAfter, "is limited to", manifestMaxBackoff)
+			retryAfter = manifestMaxBackoff
+		}
+		p.nextPoll = time.Now().Add(retryAfter)
+		return
+	}
+	if p.backoff == 0 {
+		p.backoff = time.Duration(timeoutSec) * time.Second
+		if p.backoff < time.Second {
+			p.backoff = time.Second
+		}
+	} else {
+		p.backoff *= 2
+	}
+	if p.backoff > manifestMaxBackoff {
+		p.backoff = manifestMaxBackoff
+	}
+	p.nextPoll = time.Now().Add(p.backoff)
+}
+
+// cacheExpiration returns the time the response is fresh till according to `Cache-Control: max-age`
+func cacheExpiration(resp *http.Response) time.Time {
+	for _, directive := range strings.Split(resp.Header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if directive == "no-cache" || directive == "no-store" {
+			return time.Time{}
+		}
+		if strings.HasPrefix(directive, "max-age=") {
+			maxAge, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil && maxAge > 0 {
+				return time.Now().Add(time.Duration(maxAge) * time.Second)
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// parseRetryAfter parses `Retry-After` which is either seconds or HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if date.Before(now) {
+			return 0, true
+		}
+		return date.Sub(now), true
+	}
+	return 0, false
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestTrackerURL(t *testing.T) {
+	manifestContent := "https://example.com/app-1.2.0.zip"
+	var requests int
+	var notModified int
+	var cacheControl string
+	var status int
+	retryAfterHeader := "120"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if status != 0 {
+			w.Header().Set("Retry-After", retryAfterHeader)
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Cache-Control", cacheControl)
+		etag := `"` + manifestContent + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(manifestContent))
+	}))
+	defer ts.Close()
+
+	tracker := newManifestTrackerURL()
+	manifest, ok := tracker.GetManifest(ts.URL)
+	require.True(t, ok)
+	require.Equal(t, "https://example.com/app-1.2.0.zip", manifest.Artifacts[0].URL)
+
+	// not modified
+	manifest, ok = tracker.GetManifest(ts.URL)
+	require.True(t, ok)
+	require.Equal(t, "https://example.com/app-1.2.0.zip", manifest.Artifacts[0].URL)
+	require.Equal(t, 1, notModified)
+
+	// modified
+	manifestContent = "https://example.com/app-1.3.0.zip"
+	cacheControl = "public, max-age=60"
+	manifest, ok = tracker.GetManifest(ts.URL)
+	require.True(t, ok)
+	require.Equal(t, "https://example.com/app-1.3.0.zip", manifest.Artifacts[0].URL)
+
+	// fresh according to max-age, not requested
+	requests = 0
+	manifest, ok = tracker.GetManifest(ts.URL)
+	require.True(t, ok)
+	require.Equal(t, "https://example.com/app-1.3.0.zip", manifest.Artifacts[0].URL)
+	require.Equal(t, 0, requests)
+
+	// too many requests -> postponed according to Retry-After
+	tracker.polls[ts.URL].nextPoll = time.Time{}
+	status = http.StatusTooManyRequests
+	_, ok = tracker.GetManifest(ts.URL)
+	require.False(t, ok)
+	require.Equal(t, 1, requests)
+	require.True(t, tracker.polls[ts.URL].nextPoll.After(time.Now().Add(110*time.Second)))
+	tracker.GetManifest(ts.URL)
+	require.Equal(t, 1, requests)
+
+	// too long Retry-After is limited
+	tracker.polls[ts.URL].nextPoll = time.Time{}
+	retryAfterHeader = "31536000"
+	_, ok = tracker.GetManifest(ts.URL)
+	require.False(t, ok)
+	require.False(t, tracker.polls[ts.URL].nextPoll.After(time.Now().Add(manifestMaxBackoff)))
+
+	retryAfter, ok := parseRetryAfter("Wed, 21 Oct 2015 07:28:30 GMT", time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC))
+	require.True(t, ok)
+	require.Equal(t, 30*time.Second, retryAfter)
+}
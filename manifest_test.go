@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseManifest(t *testing.T) {
+	// legacy
+	m, err := parseManifest([]byte("https://example.com/a.zip\r\nhttps://example.com/deploy.sh\n"))
+	require.Nil(t, err)
+	require.Equal(t, &artifactManifest{Artifacts: []manifestArtifact{{URL: "https://example.com/a.zip"}}, DeployerURL: "https://example.com/deploy.sh"}, m)
+	require.Equal(t, "https://example.com/a.zip", m.artifactsKey())
+
+	m, err = parseManifest([]byte("https://example.com/a.zip"))
+	require.Nil(t, err)
+	require.Empty(t, m.DeployerURL)
+
+	// JSON
+	m, err = parseManifest([]byte(`{
+		"formatVersion": 2,
+		"version": "1.2.0",
+		"artifacts": [{"url": "https://example.com/a.zip", "sha256": "00ff"}, {"url": "https://example.com/b.zip"}],
+		"env": {"B": "2", "A": "1"},
+		"args": ["--verbose"]
+	}`))
+	require.Nil(t, err)
+	require.Equal(t, "1.2.0", m.Version)
+	require.Len(t, m.Artifacts, 2)
+	require.Equal(t, "https://example.com/a.zip#00ff\nhttps://example.com/b.zip", m.artifactsKey())
+	require.Equal(t, []string{"A=1", "B=2"}, m.deployEnv())
+	require.Equal(t, []string{"--verbose"}, m.Args)
+
+	// YAML
+	m, err = parseManifest([]byte(`
+formatVersion: 2
+artifacts:
+  - url: https://example.com/a.zip
+deployerURL: https://example.com/deploy.sh
+env:
+  PORT: "8080"
+`))
+	require.Nil(t, err)
+	require.Equal(t, "https://example.com/deploy.sh", m.DeployerURL)
+	require.Equal(t, []string{"PORT=8080"}, m.deployEnv())
+
+	// errors
+	for _, content := range []string{"", "a\nb\nc", `{"formatVersion": 2}`, `{"artifacts": [`, "formatVersion: 3\nartifacts:\n  - url: a.zip"} {
+		_, err = parseManifest([]byte(content))
+		require.NotNil(t, err, content)
+	}
+}
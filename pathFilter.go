@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"strings"
+
+	gc "github.com/untillpro/gochips"
+)
+
+var (
+	includePaths []string
+	ignorePaths  []string
+)
+
+// pathFilter decides if a commit touched files which should trigger a deploy
+type pathFilter struct {
+	include []string
+	exclude []string
+}
+
+// getPathFilter returns the filter for the repo built of `--paths` and `--ignore`. nil -> any change triggers a deploy
+// Items are `<glob>` (applied to all repos) or `<repo url>=<glob>`
+func getPathFilter(repoURL string) *pathFilter {
+	filterURL, _ := parseRepoURL(repoURL)
+	filterURL = normalizeRepoURL(filterURL)
+	forRepo := func(items []string) (res []string) {
+		for _, item := range items {
+			if pos := strings.LastIndex(item, "="); pos >= 0 {
+				itemURL, _ := parseRepoURL(item[:pos])
+				if normalizeRepoURL(itemURL) != filterURL {
+					continue
+				}
+				item = item[pos+1:]
+			}
+			res = append(res, item)
+		}
+		return
+	}
+	res := &pathFilter{
+		include: forRepo(includePaths),
+		exclude: forRepo(ignorePaths),
+	}
+	if len(res.include) == 0 && len(res.exclude) == 0 {
+		return nil
+	}
+	return res
+}
+
+// changed returns true if files changed between the commits match the filter. Diff failed -> true
+func (f *pathFilter) changed(repoPath string, fromCommit string, toCommit string) bool {
+	stdout, stderr, err := new(gc.PipedExec).
+		Command("git", "diff", "--name-only", "--no-renames", fromCommit, toCommit).
+		WorkingDir(repoPath).
+		RunToStrings()
+	if err != nil {
+		gc.Error("pathFilter: failed to diff "+fromCommit+".."+toCommit+", considered as changed:", err, stderr)
+		return true
+	}
+	for _, file := range strings.Split(stdout, "\n") {
+		file = strings.TrimSpace(file)
+		if len(file) == 0 {
+			continue
+		}
+		if len(f.include) > 0 && !matchAnyGlob(f.include, file) {
+			continue
+		}
+		if matchAnyGlob(f.exclude, file) {
+			continue
+		}
+		gc.Verbose("pathFilter", "Matching file changed", repoPath, file)
+		return true
+	}
+	return false
+}
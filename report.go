@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotify/go-api-client/v2/auth"
+	"github.com/gotify/go-api-client/v2/client/message"
+	"github.com/gotify/go-api-client/v2/models"
+	gc "github.com/untillpro/gochips"
+)
+
+const (
+	reportLogTailSize      = 4096
+	reportPrioritySuccess  = 4
+	reportPriorityFailure  = 8
+	reportFailureMaxLength = 1024
+)
+
+var (
+	reportURL   string
+	reportToken string
+	// output of deployers during the current deploy
+	deployLog = &tailBuffer{max: reportLogTailSize}
+)
+
+// tailBuffer keeps last `max` bytes written
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = append([]byte{}, b.buf[len(b.buf)-b.max:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = nil
+}
+
+// tail returns kept bytes starting from the first full line
+func (b *tailBuffer) tail() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := string(b.buf)
+	if len(b.buf) == b.max {
+		if pos := strings.Index(res, "\n"); pos >= 0 {
+			res = res[pos+1:]
+		}
+	}
+	return strings.TrimSpace(res)
+}
+
+// deployWriters are writers for deployer commands output. Output is kept in `deployLog` to be reported
+func deployWriters(stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	return io.MultiWriter(stdout, deployLog), io.MultiWriter(stderr, deployLog)
+}
+
+// deploy deploys changed repos and reports the result, panic is passed through
+func deploy(changedRepos []string) {
+	started := time.Now()
+	deployLog.reset()
+	defer func() {
+		r := recover()
+		reportDeployment(changedRepos, time.Since(started), r)
+		if r != nil {
+			panic(r)
+		}
+	}()
+	for _, changedRepo := range changedRepos {
+		deployer.Deploy(changedRepo)
+	}
+	deployer.DeployAll(changedRepos)
+	watcher.Commit(changedRepos)
+	state.save()
+}
+
+// reportDeployment posts the deploy result to Gotify app specified by `--report-token`. Failed report does not fail the deploy
+func reportDeployment(changedRepos []string, duration time.Duration, r interface{}) {
+	if len(reportURL) == 0 || len(reportToken) == 0 {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			gc.Error("reportDeployment: Recovered: ", r)
+		}
+	}()
+	outcome := "succeeded"
+	priority := reportPrioritySuccess
+	if r != nil {
+		outcome = "failed"
+		priority = reportPriorityFailure
+	}
+	var msg strings.Builder
+	var mainRepo string
+	for i, repoPath := range changedRepos {
+		repo, revision := watcher.Revision(repoPath)
+		if i == 0 {
+			mainRepo = repo
+		}
+		fmt.Fprintf(&msg, "%s %s\n", repo, revision)
+	}
+	fmt.Fprintf(&msg, "Duration: %s\n", duration.Round(time.Second))
+	if r != nil {
+		failure := fmt.Sprint(r)
+		if len(failure) > reportFailureMaxLength {
+			failure = failure[:reportFailureMaxLength] + "..."
+		}
+		fmt.Fprintf(&msg, "Error: %s\n", failure)
+	}
+	if logTail := deployLog.tail(); len(logTail) > 0 {
+		fmt.Fprintf(&msg, "\n%s\n", logTail)
+	}
+	hostname, _ := os.Hostname()
+	title := fmt.Sprintf("Deploy %s: %s", outcome, mainRepo)
+	if len(hostname) > 0 {
+		title += " at " + hostname
+	}
+
+	createMessageParams := message.NewCreateMessageParams().WithBody(&models.MessageExternal{
+		Title:    title,
+		Message:  msg.String(),
+		Priority: priority,
+	})
+	_, err := getGotifyClient(reportURL).Message.CreateMessage(createMessageParams, auth.TokenAuth(reportToken))
+	gc.PanicIfError(err)
+	gc.Verbose("reportDeployment", "Reported:", title)
+}
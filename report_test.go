@@ -0,0 +1,27 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 12}
+	b.Write([]byte("first\n"))
+	require.Equal(t, "first", b.tail())
+
+	// partially kept line is cut
+	b.Write([]byte("second\nthird\n"))
+	require.Equal(t, "third", b.tail())
+
+	b.reset()
+	require.Empty(t, b.tail())
+}
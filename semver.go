@@ -0,0 +1,212 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// semVersion is a version according to https://semver.org. `v` prefix is allowed
+type semVersion struct {
+	major, minor, patch uint64
+	pre                 []string
+	// number of version parts specified: 1.4 -> 2. Used by constraints only
+	parts int
+}
+
+// semConstraint is a single comparison: >=1.4.0, ~1.2, ^2
+type semConstraint struct {
+	op      string
+	version *semVersion
+}
+
+// semConstraints are alternatives (`||`) of constraints sets which must be satisfied all
+type semConstraints [][]semConstraint
+
+var (
+	semVersionRegexp = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+	semOpSpaceRegexp = regexp.MustCompile(`(>=|<=|!=|>|<|=|~|\^)\s+`)
+	semOpRegexp      = regexp.MustCompile(`^(>=|<=|!=|>|<|=|~|\^)?(.*)$`)
+)
+
+func parseSemVersion(s string) (*semVersion, error) {
+	matches := semVersionRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if matches == nil {
+		return nil, fmt.Errorf("invalid semantic version: %s", s)
+	}
+	res := &semVersion{}
+	for i, dst := range []*uint64{&res.major, &res.minor, &res.patch} {
+		if len(matches[i+1]) == 0 {
+			break
+		}
+		n, err := strconv.ParseUint(matches[i+1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semantic version: %s: %w", s, err)
+		}
+		*dst = n
+		res.parts++
+	}
+	if len(matches[4]) > 0 {
+		res.pre = strings.Split(matches[4], ".")
+	}
+	return res, nil
+}
+
+func (v *semVersion) String() string {
+	res := fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+	if len(v.pre) > 0 {
+		res += "-" + strings.Join(v.pre, ".")
+	}
+	return res
+}
+
+// compare returns -1, 0 or 1 if `v` is less, equal or greater than `other`
+func (v *semVersion) compare(other *semVersion) int {
+	for _, pair := range [][2]uint64{{v.major, other.major}, {v.minor, other.minor}, {v.patch, other.patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+	// pre-release version has lower precedence than the normal version
+	switch {
+	case len(v.pre) == 0 && len(other.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(other.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(other.pre); i++ {
+		if res := comparePreReleaseIdentifiers(v.pre[i], other.pre[i]); res != 0 {
+			return res
+		}
+	}
+	switch {
+	case len(v.pre) < len(other.pre):
+		return -1
+	case len(v.pre) > len(other.pre):
+		return 1
+	}
+	return 0
+}
+
+func comparePreReleaseIdentifiers(a, b string) int {
+	aNum, aErr := strconv.ParseUint(a, 10, 64)
+	bNum, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		if aNum == bNum {
+			return 0
+		}
+		if aNum < bNum {
+			return -1
+		}
+		return 1
+	case aErr == nil:
+		// numeric identifiers have lower precedence
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// parseSemConstraints parses constraints like ">=1.4.0 <2.0.0", "~1.2 || ^2.0.1". Space or comma means AND
+func parseSemConstraints(s string) (semConstraints, error) {
+	var res semConstraints
+	for _, alternative := range strings.Split(s, "||") {
+		alternative = semOpSpaceRegexp.ReplaceAllString(alternative, "$1")
+		var set []semConstraint
+		for _, item := range strings.FieldsFunc(alternative, func(r rune) bool { return r == ' ' || r == ',' }) {
+			if item == "*" {
+				continue
+			}
+			matches := semOpRegexp.FindStringSubmatch(item)
+			version, err := parseSemVersion(matches[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid constraint %s: %w", item, err)
+			}
+			set = append(set, semConstraint{op: matches[1], version: version})
+		}
+		res = append(res, set)
+	}
+	return res, nil
+}
+
+// check returns true if `v` satisfies the constraints
+func (c semConstraints) check(v *semVersion) bool {
+	for _, set := range c {
+		satisfied := true
+		for _, constraint := range set {
+			if !constraint.check(v) {
+				satisfied = false
+				break
+			}
+		}
+		if satisfied {
+			return true
+		}
+	}
+	return false
+}
+
+func (c semConstraint) check(v *semVersion) bool {
+	cmp := v.compare(c.version)
+	switch c.op {
+	case "", "=":
+		return c.inRange(v, c.version.parts)
+	case "!=":
+		return !c.inRange(v, c.version.parts)
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "~":
+		// ~1.2.3 -> >=1.2.3 <1.3.0, ~1 -> >=1.0.0 <2.0.0
+		parts := c.version.parts
+		if parts > 2 {
+			parts = 2
+		}
+		return cmp >= 0 && c.inRange(v, parts)
+	case "^":
+		// ^1.2.3 -> >=1.2.3 <2.0.0, ^0.2.3 -> >=0.2.3 <0.3.0, ^0.0.3 -> >=0.0.3 <0.0.4
+		parts := 1
+		if c.version.major == 0 && c.version.parts > 1 {
+			parts = 2
+			if c.version.minor == 0 && c.version.parts > 2 {
+				parts = 3
+			}
+		}
+		return cmp >= 0 && c.inRange(v, parts)
+	}
+	return false
+}
+
+// inRange returns true if first `parts` parts of `v` equal to ones of the constraint version
+func (c semConstraint) inRange(v *semVersion, parts int) bool {
+	if parts >= 3 {
+		return v.compare(c.version) == 0
+	}
+	if parts >= 1 && v.major != c.version.major {
+		return false
+	}
+	if parts >= 2 && v.minor != c.version.minor {
+		return false
+	}
+	return true
+}
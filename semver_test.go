@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemConstraints(t *testing.T) {
+	cases := []struct {
+		constraints string
+		version     string
+		expected    bool
+	}{
+		{">=1.4.0 <2.0.0", "1.4.0", true},
+		{">=1.4.0 <2.0.0", "v1.9.12", true},
+		{">=1.4.0 <2.0.0", "2.0.0", false},
+		{">= 1.4.0, < 2.0.0", "1.3.9", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "2.0.0", false},
+		{"^0.2.3", "0.3.0", false},
+		{"1.2", "1.2.7", true},
+		{"!=1.2.7", "1.2.7", false},
+		{"<1.0.0 || >=3", "3.1.0", true},
+		{"<1.0.0 || >=3", "2.1.0", false},
+		{"*", "0.0.1", true},
+	}
+	for _, c := range cases {
+		constraints, err := parseSemConstraints(c.constraints)
+		require.Nil(t, err, c.constraints)
+		version, err := parseSemVersion(c.version)
+		require.Nil(t, err, c.version)
+		require.Equal(t, c.expected, constraints.check(version), c.constraints+" "+c.version)
+	}
+
+	_, err := parseSemConstraints(">=one")
+	require.NotNil(t, err)
+}
+
+func TestSemVersionOrder(t *testing.T) {
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.10.0"}
+	for i := 1; i < len(ordered); i++ {
+		prev, err := parseSemVersion(ordered[i-1])
+		require.Nil(t, err)
+		next, err := parseSemVersion(ordered[i])
+		require.Nil(t, err)
+		require.Equal(t, -1, prev.compare(next), ordered[i-1]+" < "+ordered[i])
+		require.Equal(t, 1, next.compare(prev), ordered[i]+" > "+ordered[i-1])
+	}
+}
+
+func TestFindNewestTag(t *testing.T) {
+	constraints, err := parseSemConstraints(">=1.4.0 <2.0.0")
+	require.Nil(t, err)
+	refs := map[string]string{
+		"refs/tags/v1.3.0":       "a",
+		"refs/tags/v1.4.0":       "b",
+		"refs/tags/v1.5.1":       "c",
+		"refs/tags/v1.6.0-rc.1":  "d",
+		"refs/tags/v2.0.0":       "e",
+		"refs/tags/nightly":      "f",
+		"refs/tags/v1.5.1-extra": "g",
+	}
+	tracker := &gitTrackerTags{constraints: constraints}
+	tag, commit := tracker.findNewestTag(refs)
+	require.Equal(t, "v1.5.1", tag)
+	require.Equal(t, "c", commit)
+
+	tracker.includePrerelease = true
+	tag, commit = tracker.findNewestTag(refs)
+	require.Equal(t, "v1.6.0-rc.1", tag)
+	require.Equal(t, "d", commit)
+}
+
+type gitTrackerStub struct {
+	calls int
+}
+
+func (t *gitTrackerStub) GetLastCommit(repoURL string, repoPath string) (string, bool) {
+	t.calls++
+	return "stub", true
+}
+
+func TestGitTrackerTagsFallback(t *testing.T) {
+	repoDir, err := ioutil.TempDir("", "cder-tags")
+	require.Nil(t, err)
+	defer os.RemoveAll(repoDir)
+	initTestRepo(t, repoDir)
+	commitTestRepo(t, repoDir, "initial")
+
+	constraints, err := parseSemConstraints(">=1.0.0")
+	require.Nil(t, err)
+	fallback := &gitTrackerStub{}
+	tracker := &gitTrackerTags{constraints: constraints, fallback: fallback, untagged: map[string]bool{}}
+
+	// untagged repo is tracked by the fallback tracker instead of panic
+	for i := 0; i < 2; i++ {
+		commit, ok := tracker.GetLastCommit(repoDir, "")
+		require.True(t, ok)
+		require.Equal(t, "stub", commit)
+	}
+	require.Equal(t, 2, fallback.calls)
+	require.True(t, tracker.untagged[repoDir])
+}
@@ -0,0 +1,186 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	gc "github.com/untillpro/gochips"
+)
+
+const stateFileName = "cder-state.json"
+
+// cderState is what was deployed last time. Saved to `<--working-dir>/cder-state.json` after each successful deploy,
+// so restart of cder does not rebuild and redeploy unchanged revisions
+type cderState struct {
+	// repoPath -> last commit hash
+	Commits map[string]string `json:"commits"`
+	// watched url -> deployed artifact
+	URLs map[string]*urlState `json:"urls"`
+	// absolute path to the binary built by deployer4go
+	Binary string `json:"binary,omitempty"`
+	// args the binary was started with, including `Cder-Args` of the deployed commits
+	BinaryArgs []string `json:"binaryArgs,omitempty"`
+	// `Cder-Args` of the deployed commits deploy.sh was run with
+	DeployerArgs []string `json:"deployerArgs,omitempty"`
+}
+
+type urlState struct {
+	// artifact url or artifactManifest.artifactsKey() if there are few artifacts
+	ArtifactURL string   `json:"artifactURL"`
+	DeployerURL string   `json:"deployerURL"`
+	Env         []string `json:"env,omitempty"`
+	Args        []string `json:"args,omitempty"`
+	// id of the current version, see artifactManifest.versionID()
+	Version string `json:"version,omitempty"`
+	// version which is rolled back from. Manifest of this version is ignored till the manifest is changed
+	RolledBackFrom string `json:"rolledBackFrom,omitempty"`
+	// kept versions, oldest first, see `--keep-versions`
+	History []*urlVersion `json:"history,omitempty"`
+}
+
+// urlVersion is the version kept at `<--working-dir>/artifacts/<url>/versions/<id>`
+type urlVersion struct {
+	ID string `json:"id"`
+	// artifactManifest.Version
+	ManifestVersion string    `json:"manifestVersion,omitempty"`
+	ArtifactURL     string    `json:"artifactURL"`
+	DeployerURL     string    `json:"deployerURL"`
+	Env             []string  `json:"env,omitempty"`
+	Args            []string  `json:"args,omitempty"`
+	Deployed        time.Time `json:"deployed"`
+}
+
+var (
+	state = newCderState()
+	// modification time of the state file when it was loaded or saved last time
+	stateModTime time.Time
+)
+
+func newCderState() *cderState {
+	return &cderState{
+		Commits: map[string]string{},
+		URLs:    map[string]*urlState{},
+	}
+}
+
+func getStateFilePath() string {
+	return path.Join(workingDir, stateFileName)
+}
+
+// loadState reads the state file. Broken or absent file means nothing is deployed
+func loadState() {
+	state = newCderState()
+	stateBytes, err := ioutil.ReadFile(getStateFilePath())
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(stateBytes, state)
+	}
+	if err != nil {
+		gc.Error("loadState: state file is ignored:", err)
+		state = newCderState()
+		return
+	}
+	if state.Commits == nil {
+		state.Commits = map[string]string{}
+	}
+	if state.URLs == nil {
+		state.URLs = map[string]*urlState{}
+	}
+	stateModTime = getStateModTime()
+	gc.Info("loadState: state loaded from", getStateFilePath())
+}
+
+func (s *cderState) save() {
+	stateBytes, err := json.MarshalIndent(s, "", "  ")
+	gc.PanicIfError(err)
+	gc.PanicIfError(os.MkdirAll(workingDir, 0755))
+	tmpPath := getStateFilePath() + ".tmp"
+	gc.PanicIfError(ioutil.WriteFile(tmpPath, stateBytes, 0644))
+	gc.PanicIfError(os.Rename(tmpPath, getStateFilePath()))
+	stateModTime = getStateModTime()
+	gc.Verbose("cderState", "Saved to "+getStateFilePath())
+}
+
+// reset forgets everything deployed. Maps are cleared in place since watchers refer to them
+func (s *cderState) reset() {
+	for repoPath := range s.Commits {
+		delete(s.Commits, repoPath)
+	}
+	for url := range s.URLs {
+		delete(s.URLs, url)
+	}
+	s.Binary = ""
+	s.BinaryArgs = nil
+	s.DeployerArgs = nil
+}
+
+// reloadURLStates reads url states from the state file if it is changed by another process, e.g. by `rollback` command.
+// Maps are updated in place since watchers refer to them
+func reloadURLStates() {
+	modTime := getStateModTime()
+	if modTime.IsZero() || modTime.Equal(stateModTime) {
+		return
+	}
+	stateBytes, err := ioutil.ReadFile(getStateFilePath())
+	gc.PanicIfError(err)
+	loaded := newCderState()
+	if err := json.Unmarshal(stateBytes, loaded); err != nil {
+		gc.Error("reloadURLStates: state file is ignored:", err)
+		return
+	}
+	for url, stored := range loaded.URLs {
+		state.URLs[url] = stored
+	}
+	stateModTime = modTime
+	gc.Info("reloadURLStates: state is changed by another process, reloaded")
+}
+
+func getStateModTime() time.Time {
+	info, err := os.Stat(getStateFilePath())
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// version returns kept version by id, nil if not found
+func (s *urlState) version(id string) *urlVersion {
+	for _, version := range s.History {
+		if version.ID == id {
+			return version
+		}
+	}
+	return nil
+}
+
+// addVersion adds `version` to the end of the history, replaces the kept one with the same id
+func (s *urlState) addVersion(version *urlVersion) {
+	history := []*urlVersion{}
+	for _, kept := range s.History {
+		if kept.ID != version.ID {
+			history = append(history, kept)
+		}
+	}
+	s.History = append(history, version)
+}
+
+// setVersion makes `version` current
+func (s *urlState) setVersion(version *urlVersion) {
+	s.Version = version.ID
+	s.ArtifactURL = version.ArtifactURL
+	s.DeployerURL = version.DeployerURL
+	s.Env = version.Env
+	s.Args = version.Args
+}
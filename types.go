@@ -11,6 +11,8 @@ package main
 type IDeployer interface {
 	Deploy(repo string)
 	DeployAll(repos []string)
+	// starts already deployed repos without rebuilding. Used on cder restart
+	Start(repos []string)
 	Stop()
 }
 
@@ -18,12 +20,32 @@ type IDeployer interface {
 type IWatcher interface {
 	Watch(repos []string) (changedRepoPaths []string) // [0] must be main
 	Clean(repoPathsToClean []string)
+	// returns paths of repos which are deployed already according to the stored state
+	Deployed(repos []string) (deployedRepoPaths []string) // [0] must be main
+	// returns watched repo and its deployed revision by the path returned from Watch()
+	Revision(repoPath string) (repo string, revision string)
+	// stores revisions of repos returned from Watch() which are deployed successfully
+	Commit(repoPaths []string)
+}
+
+// IManifestTracker s.e.
+type IManifestTracker interface {
+	// retrieves artifact and deployer to be deployed for `repo` watched by watcherURL.
+	// !ok -> nothing is published
+	GetManifest(repo string) (manifest *artifactManifest, ok bool)
 }
 
 // IGitTracker s.e.
 type IGitTracker interface {
 	// retrieves last commit from repo defined by `repoURL`.
+	// `repoURL` could specify the revision to track, see parseRepoURL
 	// len(repoPath) > 0 -> the repo must be cloned already to `repoPath`.
 	// !ok -> no commits or no notifications about commits
 	GetLastCommit(repoURL string, repoPath string) (lastCommit string, ok bool)
 }
+
+// IIterationTracker is implemented by trackers which request all repos at once per iteration.
+// Watchers call StartIteration once before the repos of the iteration are asked
+type IIterationTracker interface {
+	StartIteration()
+}
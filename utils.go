@@ -11,31 +11,178 @@ import (
 	"net/url"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	gc "github.com/untillpro/gochips"
 )
 
+// gitRef is the revision of the repo to track. Empty gitRef means the default branch
+type gitRef struct {
+	branch string
+	tag    string
+	commit string
+}
+
+var (
+	commitHashRegexp = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+	// repo specs which `@<rev>` looks like a commit hash but is a tag of the remote repo, see resolveRepoRevision
+	tagRevisions = map[string]bool{}
+	// chars which are replaced in host and revision parts of repo folders
+	folderNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+)
+
+func (r gitRef) String() string {
+	return r.branch + r.tag + r.commit
+}
+
+// parseRepoURL splits repo spec into repo url and revision to track:
+// <url>#<branch>, <url>@<tag>, <url>@<commit hash>. Revision which looks like a commit hash is a commit unless resolved as a tag
+func parseRepoURL(repoSpec string) (repoURL string, ref gitRef) {
+	repoURL = repoSpec
+	if pos := strings.Index(repoURL, "#"); pos >= 0 {
+		ref.branch = repoURL[pos+1:]
+		repoURL = repoURL[:pos]
+	}
+	// `@` could be used in user info or in scp-like syntax before path
+	pathStart := 0
+	if pos := strings.Index(repoURL, "://"); pos >= 0 {
+		pathStart = pos + len("://")
+		if pos := strings.Index(repoURL[pathStart:], "/"); pos >= 0 {
+			pathStart += pos
+		} else {
+			pathStart = len(repoURL)
+		}
+	} else if pos := strings.Index(repoURL, ":"); pos >= 0 {
+		pathStart = pos + 1
+	}
+	if pos := strings.Index(repoURL[pathStart:], "@"); pos >= 0 && len(ref.branch) == 0 {
+		rev := repoURL[pathStart+pos+1:]
+		repoURL = repoURL[:pathStart+pos]
+		if commitHashRegexp.MatchString(strings.ToLower(rev)) && !tagRevisions[repoSpec] {
+			ref.commit = strings.ToLower(rev)
+		} else {
+			ref.tag = rev
+		}
+	}
+	return
+}
+
 // GetAbsRepoFolders  ...
 // <reposFolder>/<repoFolder>
 // <repoPath                >
-//  repoPath = reposFolder + '/' + repoFolder
-func getAbsRepoFolders(repoURL string) (repoPath string, repoFolder string) {
-	u, err := url.Parse(repoURL)
-	gc.PanicIfError(err)
-	urlParts := strings.Split(u.Path, "/")
-	repoFolder = urlParts[len(urlParts)-1]
+//
+//	repoPath = reposFolder + '/' + repoFolder
+//	repoFolder = <host>/<owner>/<name>, e.g. github.com/untillpro/airs-bp
+//
+// name is suffixed by the revision if `repoSpec` contains it, see parseRepoURL
+func getAbsRepoFolders(repoSpec string) (repoPath string, repoFolder string) {
+	repoURL, ref := parseRepoURL(repoSpec)
+	host, urlPath := splitRepoURL(repoURL)
+	var folderParts []string
+	if len(host) > 0 {
+		folderParts = append(folderParts, folderNameRegexp.ReplaceAllString(host, "_"))
+	}
+	for _, part := range strings.Split(strings.TrimSuffix(urlPath, ".git"), "/") {
+		if len(part) > 0 && part != "." && part != ".." {
+			folderParts = append(folderParts, part)
+		}
+	}
+	if rev := ref.String(); len(rev) > 0 {
+		folderParts[len(folderParts)-1] += "_" + folderNameRegexp.ReplaceAllString(rev, "_")
+	}
+	repoFolder = path.Join(folderParts...)
 	repoPath, _ = filepath.Abs(path.Join(getReposFolder(), repoFolder))
 	return
 }
 
+// getLegacyRepoPath returns the folder used before <host>/<owner>/<name> layout: <reposFolder>/<name>.
+// Revisions were not supported then, so there is no legacy folder for `repoSpec` with revision
+func getLegacyRepoPath(repoSpec string) string {
+	repoURL, ref := parseRepoURL(repoSpec)
+	if len(ref.String()) > 0 {
+		return ""
+	}
+	_, urlPath := splitRepoURL(repoURL)
+	legacyPath, _ := filepath.Abs(path.Join(getReposFolder(), path.Base(urlPath)))
+	return legacyPath
+}
+
+// splitRepoURL returns host and path of the repo url. Scp-like syntax (git@github.com:untillpro/cder) is supported
+func splitRepoURL(repoURL string) (host string, urlPath string) {
+	if !strings.Contains(repoURL, "://") {
+		if pos := strings.Index(repoURL, ":"); pos >= 0 {
+			host, urlPath = repoURL[:pos], repoURL[pos+1:]
+			if pos := strings.LastIndex(host, "@"); pos >= 0 {
+				host = host[pos+1:]
+			}
+			return
+		}
+	}
+	u, err := url.Parse(repoURL)
+	gc.PanicIfError(err)
+	return u.Host, u.Path
+}
+
+// matchGlob matches slash-separated `name` against `pattern`. `**` matches any number of directories.
+// Pattern without slashes matches the base name at any depth: `*.md`. Trailing slash means the whole dir: `docs/`
+func matchGlob(pattern string, name string) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchGlobParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchGlobParts(patternParts []string, nameParts []string) bool {
+	for len(patternParts) > 0 {
+		if patternParts[0] == "**" {
+			for i := 0; i <= len(nameParts); i++ {
+				if matchGlobParts(patternParts[1:], nameParts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(nameParts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(patternParts[0], nameParts[0]); !ok {
+			return false
+		}
+		patternParts, nameParts = patternParts[1:], nameParts[1:]
+	}
+	return len(nameParts) == 0
+}
+
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
 func parseArtifactURL(repoURL string) (aPath, aFN string) {
 	u, err := url.Parse(repoURL)
 	gc.PanicIfError(err)
 	urlParts := strings.Split(u.Path, "/")
 	aFN = urlParts[len(urlParts)-1]
-	lastDotPos := strings.LastIndex(aFN, ".")
-	artifactName := aFN[:lastDotPos]
+	if len(aFN) == 0 {
+		aFN = "artifact"
+	}
+	artifactName := aFN
+	for _, item := range formatExtensions {
+		if strings.HasSuffix(strings.ToLower(artifactName), item.ext) {
+			artifactName = artifactName[:len(artifactName)-len(item.ext)]
+			break
+		}
+	}
 	artifactPath, _ := filepath.Abs(path.Join(getArtifactsFolder(), artifactName))
 	return artifactPath, aFN
 }
@@ -47,3 +194,53 @@ func getReposFolder() string {
 func getArtifactsFolder() string {
 	return path.Join(workingDir, "artifacts")
 }
+
+// shellQuoteArgs joins args so shell splits them back, e.g. `eval set -- $CDER_ARGS`. Args of safe chars only are not quoted
+func shellQuoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if len(arg) > 0 && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=.,:/@%+") == "" {
+			quoted[i] = arg
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// splitShellArgs splits `s` into args like shell does: by whitespace, single and double quotes group args,
+// backslash escapes the next char outside single quotes. Variables are not expanded
+func splitShellArgs(s string) (args []string) {
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, c := range s {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return
+}
@@ -0,0 +1,186 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	gc "github.com/untillpro/gochips"
+)
+
+func TestParseRepoURL(t *testing.T) {
+	cases := []struct {
+		spec string
+		url  string
+		ref  gitRef
+	}{
+		{"https://github.com/untillpro/cder", "https://github.com/untillpro/cder", gitRef{}},
+		{"https://github.com/untillpro/cder#develop", "https://github.com/untillpro/cder", gitRef{branch: "develop"}},
+		{"https://github.com/untillpro/cder#feature/x", "https://github.com/untillpro/cder", gitRef{branch: "feature/x"}},
+		{"https://github.com/untillpro/cder@v1.2.0", "https://github.com/untillpro/cder", gitRef{tag: "v1.2.0"}},
+		{"https://github.com/untillpro/cder@c34426a", "https://github.com/untillpro/cder", gitRef{commit: "c34426a"}},
+		{"https://github.com/untillpro/cder@C34426A", "https://github.com/untillpro/cder", gitRef{commit: "c34426a"}},
+		{"https://user@github.com/untillpro/cder@release/1.0", "https://user@github.com/untillpro/cder", gitRef{tag: "release/1.0"}},
+		{"git@github.com:untillpro/cder", "git@github.com:untillpro/cder", gitRef{}},
+		{"git@github.com:untillpro/cder#main", "git@github.com:untillpro/cder", gitRef{branch: "main"}},
+	}
+	for _, c := range cases {
+		repoURL, ref := parseRepoURL(c.spec)
+		require.Equal(t, c.url, repoURL, c.spec)
+		require.Equal(t, c.ref, ref, c.spec)
+	}
+
+	defer func(wd string) { workingDir = wd }(workingDir)
+	workingDir = "."
+	_, repoFolder := getAbsRepoFolders("https://github.com/untillpro/airs-bp.git")
+	require.Equal(t, "github.com/untillpro/airs-bp", repoFolder)
+	_, repoFolder = getAbsRepoFolders("https://github.com/host6/airs-bp")
+	require.Equal(t, "github.com/host6/airs-bp", repoFolder)
+	_, repoFolder = getAbsRepoFolders("https://github.com/untillpro/airs-bp#feature/x")
+	require.Equal(t, "github.com/untillpro/airs-bp_feature_x", repoFolder)
+	_, repoFolder = getAbsRepoFolders("git@gitlab.example.com:group/sub/repo.git@v1.0.0")
+	require.Equal(t, "gitlab.example.com/group/sub/repo_v1.0.0", repoFolder)
+	_, repoFolder = getAbsRepoFolders("http://localhost:3000/org/repo")
+	require.Equal(t, "localhost_3000/org/repo", repoFolder)
+}
+
+// initTestRepo creates empty git repo in dir
+func initTestRepo(t *testing.T, dir string) {
+	require.Nil(t, new(gc.PipedExec).Command("git", "init", "-q", dir).Run(os.Stdout, os.Stderr))
+}
+
+// commitTestRepo makes empty commit in the repo and returns its hash
+func commitTestRepo(t *testing.T, dir string, message string) string {
+	require.Nil(t, new(gc.PipedExec).
+		Command("git", "-C", dir, "-c", "user.name=cder", "-c", "user.email=cder@example.com", "commit", "-q", "--allow-empty", "-m", message).
+		Run(os.Stdout, os.Stderr))
+	return getHeadCommit(dir)
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{"cmd/**", "cmd/cder/main.go", true},
+		{"cmd/**", "internal/cmd/main.go", false},
+		{"go.*", "go.mod", true},
+		{"go.*", "sub/go.sum", true},
+		{"*.md", "docs/deep/README.md", true},
+		{"docs/**", "docs/a.txt", true},
+		{"docs/", "docs/a/b.txt", true},
+		{"docs/**", "src/docs.go", false},
+		{"**/*_test.go", "a/b/c_test.go", true},
+		{"**/*_test.go", "c_test.go", true},
+		{"/main.go", "main.go", true},
+		{"src/*.go", "src/a/b.go", false},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.expected, matchGlob(c.pattern, c.name), c.pattern+" "+c.name)
+	}
+}
+
+func TestGetPathFilter(t *testing.T) {
+	defer func() {
+		includePaths, ignorePaths = nil, nil
+	}()
+	require.Nil(t, getPathFilter("https://github.com/untillpro/cder"))
+
+	includePaths = []string{"cmd/**", "https://github.com/untillpro/gochips=*.go"}
+	ignorePaths = []string{"docs/**"}
+	filter := getPathFilter("https://github.com/untillpro/cder#develop")
+	require.Equal(t, []string{"cmd/**"}, filter.include)
+	require.Equal(t, []string{"docs/**"}, filter.exclude)
+	filter = getPathFilter("https://github.com/untillpro/gochips.git")
+	require.Equal(t, []string{"cmd/**", "*.go"}, filter.include)
+}
+
+func TestCheckoutNotifiedCommit(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "cder-checkout")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	originDir := path.Join(tempDir, "origin")
+	repoDir := path.Join(tempDir, "repo")
+	initTestRepo(t, originDir)
+	first := commitTestRepo(t, originDir, "first")
+	require.Nil(t, new(gc.PipedExec).Command("git", "clone", "-q", originDir, repoDir).Run(os.Stdout, os.Stderr))
+	second := commitTestRepo(t, originDir, "second")
+
+	// wrong commits are refused without panic, the checked out commit is kept
+	_, ok := checkoutNotifiedCommit(originDir, repoDir, "not a hash")
+	require.False(t, ok)
+	_, ok = checkoutNotifiedCommit(originDir, repoDir, strings.Repeat("0", 40))
+	require.False(t, ok)
+	require.Equal(t, first, getHeadCommit(repoDir))
+
+	// uppercase hash is accepted
+	lastCommit, ok := checkoutNotifiedCommit(originDir, repoDir, strings.ToUpper(second))
+	require.True(t, ok)
+	require.Equal(t, second, lastCommit)
+	require.Equal(t, second, getHeadCommit(repoDir))
+}
+
+func TestResolveRepoRevision(t *testing.T) {
+	originDir, err := ioutil.TempDir("", "cder-revision")
+	require.Nil(t, err)
+	defer os.RemoveAll(originDir)
+	defer func() { tagRevisions = map[string]bool{} }()
+	initTestRepo(t, originDir)
+	commit := commitTestRepo(t, originDir, "initial")
+	require.Nil(t, new(gc.PipedExec).Command("git", "-C", originDir, "tag", "20201231").Run(os.Stdout, os.Stderr))
+
+	// numeric tag is resolved as tag
+	tagSpec := "file://" + originDir + "@20201231"
+	_, ref := parseRepoURL(tagSpec)
+	require.Equal(t, gitRef{commit: "20201231"}, ref)
+	resolveRepoRevision(tagSpec)
+	_, ref = parseRepoURL(tagSpec)
+	require.Equal(t, gitRef{tag: "20201231"}, ref)
+
+	// commit hash stays commit
+	commitSpec := "file://" + originDir + "@" + commit[:7]
+	resolveRepoRevision(commitSpec)
+	_, ref = parseRepoURL(commitSpec)
+	require.Equal(t, gitRef{commit: commit[:7]}, ref)
+}
+
+func TestMigrateRepoFolder(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "cder-migrate")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	defer func(wd string, s *cderState) { workingDir, state = wd, s }(workingDir, state)
+	workingDir = tempDir
+	state = newCderState()
+
+	// repos with revision were not supported by the legacy layout
+	require.Empty(t, getLegacyRepoPath("https://github.com/untillpro/cder#develop"))
+
+	repoSpec := "https://github.com/untillpro/cder"
+	legacyPath := path.Join(getReposFolder(), "cder")
+	require.Equal(t, legacyPath, getLegacyRepoPath(repoSpec))
+	initTestRepo(t, legacyPath)
+	require.Nil(t, new(gc.PipedExec).Command("git", "-C", legacyPath, "remote", "add", "origin", repoSpec+".git").Run(os.Stdout, os.Stderr))
+	state.Commits[legacyPath] = "c34426a"
+
+	// folder of another owner is not moved
+	migrateRepoFolder("https://github.com/host6/cder")
+	require.DirExists(t, legacyPath)
+
+	migrateRepoFolder(repoSpec)
+	repoPath, repoFolder := getAbsRepoFolders(repoSpec)
+	require.Equal(t, "github.com/untillpro/cder", repoFolder)
+	require.DirExists(t, path.Join(repoPath, ".git"))
+	require.NoDirExists(t, legacyPath)
+	require.Equal(t, map[string]string{repoPath: "c34426a"}, state.Commits)
+}
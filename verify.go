@@ -0,0 +1,192 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	gc "github.com/untillpro/gochips"
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	minisignAlgorithm          = "Ed"
+	minisignAlgorithmPrehashed = "ED"
+	minisignKeyIDSize          = 8
+	minisignSignatureExt       = ".minisig"
+)
+
+var (
+	trustedKeyArgs []string
+	trustedKeys    []*trustedKey
+)
+
+// trustedKey is a minisign or raw ed25519 public key
+type trustedKey struct {
+	// empty for raw ed25519 key
+	keyID     []byte
+	publicKey ed25519.PublicKey
+}
+
+// loadTrustedKeys parses `--trusted-key <file>|<base64 key>` items. Minisign public key (file or its 2nd line) or raw ed25519 key is expected
+func loadTrustedKeys() error {
+	trustedKeys = nil
+	for _, item := range trustedKeyArgs {
+		keyStr := item
+		if fileExists(item) {
+			keyBytes, err := ioutil.ReadFile(item)
+			if err != nil {
+				return fmt.Errorf("--trusted-key %s: %w", item, err)
+			}
+			keyStr = ""
+			for _, line := range strings.Split(string(keyBytes), "\n") {
+				if line = strings.TrimSpace(line); len(line) > 0 && !strings.HasPrefix(line, "untrusted comment:") {
+					keyStr = line
+				}
+			}
+		}
+		key, err := parseTrustedKey(keyStr)
+		if err != nil {
+			return fmt.Errorf("--trusted-key %s: %w", item, err)
+		}
+		trustedKeys = append(trustedKeys, key)
+	}
+	return nil
+}
+
+func parseTrustedKey(keyStr string) (*trustedKey, error) {
+	keyBytes, err := base64.StdEncoding.DecodeString(strings.TrimSpace(keyStr))
+	if err != nil {
+		return nil, err
+	}
+	switch len(keyBytes) {
+	case ed25519.PublicKeySize:
+		return &trustedKey{publicKey: keyBytes}, nil
+	case len(minisignAlgorithm) + minisignKeyIDSize + ed25519.PublicKeySize:
+		if string(keyBytes[:2]) != minisignAlgorithm {
+			return nil, fmt.Errorf("unsupported minisign key algorithm %q", keyBytes[:2])
+		}
+		return &trustedKey{keyID: keyBytes[2:10], publicKey: keyBytes[10:]}, nil
+	}
+	return nil, errors.New("minisign or ed25519 public key expected")
+}
+
+// verifyDownload panics if `file` downloaded from `url` does not match `checksum` or is not signed by any of `--trusted-key`.
+// Signature is not specified -> `<url>.minisig` is downloaded. Not `signed` -> signature is not checked
+func verifyDownload(client *http.Client, url string, file string, checksum string, signature string, signed bool) {
+	f, err := os.Open(file)
+	gc.PanicIfError(err)
+	defer f.Close()
+	sha256Hash := sha256.New()
+	blake2bHash, err := blake2b.New512(nil)
+	gc.PanicIfError(err)
+	_, err = io.Copy(io.MultiWriter(sha256Hash, blake2bHash), f)
+	gc.PanicIfError(err)
+
+	if len(checksum) > 0 {
+		verifyChecksum(sha256Hash.Sum(nil), checksum)
+		gc.Verbose("verifyDownload", "Checksum verified", url)
+	}
+	if len(trustedKeys) == 0 || !signed {
+		return
+	}
+	if len(signature) == 0 {
+		signatureBytes := readFromURL(client, url+minisignSignatureExt)
+		if signatureBytes == nil {
+			panic("verifyDownload: signature is not found for " + url)
+		}
+		signature = string(signatureBytes)
+	}
+	// whole file is read for not prehashed signatures only
+	readContent := func() ([]byte, error) { return ioutil.ReadFile(file) }
+	if err := verifyContentSignature(readContent, blake2bHash.Sum(nil), signature); err != nil {
+		panic(fmt.Sprintf("verifyDownload: %s: %v", url, err))
+	}
+	gc.Info("verifyDownload:", "Signature verified", url)
+}
+
+// verifySignature checks minisign signature or base64 raw ed25519 signature of `data` against trusted keys
+func verifySignature(data []byte, signature string) error {
+	hash := blake2b.Sum512(data)
+	return verifyContentSignature(func() ([]byte, error) { return data, nil }, hash[:], signature)
+}
+
+// verifyContentSignature is verifySignature for the content which is read by `readContent`, `prehash` is its blake2b-512
+func verifyContentSignature(readContent func() ([]byte, error), prehash []byte, signature string) error {
+	lines := strings.Split(strings.TrimSpace(signature), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	if len(lines) == 1 {
+		sig, err := base64.StdEncoding.DecodeString(lines[0])
+		if err != nil || len(sig) != ed25519.SignatureSize {
+			return errors.New("invalid ed25519 signature")
+		}
+		data, err := readContent()
+		if err != nil {
+			return err
+		}
+		for _, key := range trustedKeys {
+			if key.keyID == nil && ed25519.Verify(key.publicKey, data, sig) {
+				return nil
+			}
+		}
+		return errors.New("signature is not made by trusted keys")
+	}
+	return verifyMinisign(readContent, prehash, lines)
+}
+
+func verifyMinisign(readContent func() ([]byte, error), prehash []byte, lines []string) error {
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return errors.New("invalid minisign signature")
+	}
+	sigBytes, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sigBytes) != len(minisignAlgorithm)+minisignKeyIDSize+ed25519.SignatureSize {
+		return errors.New("invalid minisign signature")
+	}
+	globalSig, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return errors.New("invalid minisign global signature")
+	}
+	algorithm, keyID, sig := string(sigBytes[:2]), sigBytes[2:10], sigBytes[10:]
+	var message []byte
+	switch algorithm {
+	case minisignAlgorithm:
+		if message, err = readContent(); err != nil {
+			return err
+		}
+	case minisignAlgorithmPrehashed:
+		message = prehash
+	default:
+		return fmt.Errorf("unsupported minisign signature algorithm %q", algorithm)
+	}
+	trustedComment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	for _, key := range trustedKeys {
+		if !bytes.Equal(key.keyID, keyID) {
+			continue
+		}
+		if !ed25519.Verify(key.publicKey, message, sig) {
+			return errors.New("invalid signature")
+		}
+		if !ed25519.Verify(key.publicKey, append(append([]byte{}, sig...), trustedComment...), globalSig) {
+			return errors.New("invalid trusted comment signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("signature key %X is not trusted", keyID)
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestVerifySignature(t *testing.T) {
+	defer func() { trustedKeyArgs, trustedKeys = nil, nil }()
+	data := []byte("artifact content")
+
+	// minisign key, prehashed signature
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	minisignKey := base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), publicKey...))
+	hash := blake2b.Sum512(data)
+	sig := ed25519.Sign(privateKey, hash[:])
+	trustedComment := "timestamp:1600000000\tfile:artifact.zip"
+	globalSig := ed25519.Sign(privateKey, append(append([]byte{}, sig...), trustedComment...))
+	minisignSig := fmt.Sprintf("untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(append(append([]byte("ED"), keyID...), sig...)), trustedComment, base64.StdEncoding.EncodeToString(globalSig))
+
+	// raw ed25519 key
+	rawPublicKey, rawPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	rawSig := base64.StdEncoding.EncodeToString(ed25519.Sign(rawPrivateKey, data))
+
+	// no trusted keys
+	require.NotNil(t, verifySignature(data, minisignSig))
+
+	trustedKeyArgs = []string{minisignKey, base64.StdEncoding.EncodeToString(rawPublicKey)}
+	require.Nil(t, loadTrustedKeys())
+	require.Nil(t, verifySignature(data, minisignSig))
+	require.Nil(t, verifySignature(data, rawSig))
+
+	// tampered
+	require.NotNil(t, verifySignature([]byte("hijacked"), minisignSig))
+	require.NotNil(t, verifySignature([]byte("hijacked"), rawSig))
+	require.NotNil(t, verifySignature(data, strings.Replace(minisignSig, "file:artifact.zip", "file:other.zip", 1)))
+
+	trustedKeyArgs = []string{"not a key"}
+	require.NotNil(t, loadTrustedKeys())
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/spf13/cobra"
+	gc "github.com/untillpro/gochips"
+)
+
+const (
+	// artifacts/<url>/versions/<id>: artifacts and work-dir of each kept version
+	versionsDirName = "versions"
+	// artifacts/<url>/current -> versions/<id>/work-dir
+	currentVersionLink = "current"
+)
+
+var (
+	keepVersions int
+	rollbackURL  string
+	rollbackTo   string
+)
+
+func getVersionPath(repo string, id string) string {
+	return path.Join(getArtifactHomePath(repo), versionsDirName, id)
+}
+
+// getCurrentVersionPath returns work-dir of the current version which is passed to deploy.sh
+func getCurrentVersionPath(repo string) string {
+	return path.Join(getArtifactHomePath(repo), currentVersionLink)
+}
+
+// setCurrentVersion atomically re-points `current` symlink to the work-dir of version `id`
+func setCurrentVersion(repo string, id string) {
+	currentPath := getCurrentVersionPath(repo)
+	tmpPath := currentPath + ".tmp"
+	gc.PanicIfError(os.RemoveAll(tmpPath))
+	gc.PanicIfError(os.Symlink(path.Join(versionsDirName, id, "work-dir"), tmpPath))
+	gc.PanicIfError(os.Rename(tmpPath, currentPath))
+	gc.Info("setCurrentVersion:", repo, id)
+}
+
+// pruneVersions removes the oldest versions except the current one, so `--keep-versions` are kept
+func pruneVersions(repo string, stored *urlState) {
+	keep := keepVersions
+	if keep < 1 {
+		keep = 1
+	}
+	for len(stored.History) > keep {
+		i := 0
+		if stored.History[0].ID == stored.Version {
+			i = 1
+		}
+		gc.Info("pruneVersions:", "removing version", repo, stored.History[i].ID)
+		gc.PanicIfError(os.RemoveAll(getVersionPath(repo, stored.History[i].ID)))
+		stored.History = append(stored.History[:i:i], stored.History[i+1:]...)
+	}
+	// not completed or forgotten versions
+	dirs, err := ioutil.ReadDir(path.Join(getArtifactHomePath(repo), versionsDirName))
+	gc.PanicIfError(err)
+	for _, dir := range dirs {
+		if stored.version(dir.Name()) == nil {
+			gc.PanicIfError(os.RemoveAll(path.Join(getArtifactHomePath(repo), versionsDirName, dir.Name())))
+		}
+	}
+}
+
+// linkOrCopyFile hard links `src` to `dst`, copies if linking is not possible
+func linkOrCopyFile(src string, dst string) {
+	if err := os.Link(src, dst); err == nil {
+		return
+	}
+	srcFile, err := os.Open(src)
+	gc.PanicIfError(err)
+	defer srcFile.Close()
+	dstFile, err := os.Create(dst)
+	gc.PanicIfError(err)
+	defer dstFile.Close()
+	_, err = io.Copy(dstFile, srcFile)
+	gc.PanicIfError(err)
+}
+
+// runRollback switches `--url` to the previous kept version or to `--to` and deploys it. Network is not used
+func runRollback(cmd *cobra.Command, args []string) error {
+	loadState()
+	stored, ok := state.URLs[rollbackURL]
+	if !ok || len(stored.History) == 0 {
+		return fmt.Errorf("no versions are kept for %s", rollbackURL)
+	}
+	target, err := getRollbackTarget(stored, rollbackTo)
+	if err != nil {
+		return err
+	}
+	if !fileExists(path.Join(getVersionPath(rollbackURL, target.ID), "work-dir", "deploy.sh")) {
+		return fmt.Errorf("version %s is not found at %s", target.ID, getVersionPath(rollbackURL, target.ID))
+	}
+	gc.Info("rollback:", rollbackURL, stored.Version, "->", target.ID)
+	switch {
+	case target.ID == stored.RolledBackFrom:
+		stored.RolledBackFrom = ""
+	case len(stored.RolledBackFrom) == 0:
+		stored.RolledBackFrom = stored.Version
+	}
+	setCurrentVersion(rollbackURL, target.ID)
+	stored.setVersion(target)
+
+	watcher = &watcherURL{stored: state.URLs, staged: map[string]*urlState{}}
+	deployer = newDeployer4sh(rollbackURL)
+	deploy([]string{getCurrentVersionPath(rollbackURL)})
+	return nil
+}
+
+// getRollbackTarget returns the version which id or manifest version is `to`, the version kept before the current one if `to` is empty
+func getRollbackTarget(stored *urlState, to string) (*urlVersion, error) {
+	if len(to) > 0 {
+		for i := len(stored.History) - 1; i >= 0; i-- {
+			if version := stored.History[i]; version.ID == to || version.ManifestVersion == to {
+				return version, nil
+			}
+		}
+		return nil, fmt.Errorf("version %s is not kept", to)
+	}
+	for i, version := range stored.History {
+		if version.ID == stored.Version {
+			if i == 0 {
+				break
+			}
+			return stored.History[i-1], nil
+		}
+	}
+	return nil, errors.New("no previous version is kept")
+}
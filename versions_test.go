@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollback(t *testing.T) {
+	testWD, err := ioutil.TempDir("", "cder-versions")
+	require.Nil(t, err)
+	defer os.RemoveAll(testWD)
+	defer func(wd string, keep int) { workingDir, keepVersions, state = wd, keep, newCderState() }(workingDir, keepVersions)
+	workingDir = testWD
+	keepVersions = 2
+
+	repo := "https://example.com/manifest.json"
+	stored := &urlState{}
+	for _, id := range []string{"1.0.0-aaaaaaaa", "1.1.0-bbbbbbbb", "1.2.0-cccccccc"} {
+		workDir := path.Join(getVersionPath(repo, id), "work-dir")
+		require.Nil(t, os.MkdirAll(workDir, 0755))
+		deployScript := "#!/bin/sh\necho $1 " + id + " >> " + path.Join(testWD, "deployed.txt") + "\n"
+		require.Nil(t, ioutil.WriteFile(path.Join(workDir, "deploy.sh"), []byte(deployScript), 0755))
+		version := &urlVersion{ID: id, ManifestVersion: id[:5], ArtifactURL: "https://example.com/app-" + id[:5] + ".zip"}
+		stored.addVersion(version)
+		stored.setVersion(version)
+		setCurrentVersion(repo, id)
+	}
+	pruneVersions(repo, stored)
+	require.Len(t, stored.History, 2)
+	require.False(t, fileExists(getVersionPath(repo, "1.0.0-aaaaaaaa")))
+	state = newCderState()
+	state.URLs[repo] = stored
+	state.save()
+
+	_, err = getRollbackTarget(stored, "1.0.0")
+	require.NotNil(t, err)
+
+	rollbackURL, rollbackTo = repo, ""
+	require.Nil(t, runRollback(nil, nil))
+	deployed, err := ioutil.ReadFile(path.Join(testWD, "deployed.txt"))
+	require.Nil(t, err)
+	require.Equal(t, "deploy 1.1.0-bbbbbbbb\ndeploy-all 1.1.0-bbbbbbbb\n", string(deployed))
+	link, err := os.Readlink(getCurrentVersionPath(repo))
+	require.Nil(t, err)
+	require.Equal(t, "versions/1.1.0-bbbbbbbb/work-dir", link)
+
+	loadState()
+	require.Equal(t, "1.1.0-bbbbbbbb", state.URLs[repo].Version)
+	require.Equal(t, "https://example.com/app-1.1.0.zip", state.URLs[repo].ArtifactURL)
+	require.Equal(t, "1.2.0-cccccccc", state.URLs[repo].RolledBackFrom)
+
+	// no previous version
+	require.NotNil(t, runRollback(nil, nil))
+
+	// back to the version rolled back from
+	rollbackTo = "1.2.0"
+	require.Nil(t, runRollback(nil, nil))
+	loadState()
+	require.Equal(t, "1.2.0-cccccccc", state.URLs[repo].Version)
+	require.Empty(t, state.URLs[repo].RolledBackFrom)
+}
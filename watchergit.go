@@ -37,6 +37,53 @@ func (w *watcherGit) Clean(repoPathsToClean []string) {
 	}
 }
 
+// forceClean removes all untracked and ignored files including ones of submodules, restores submodules
+func (w *watcherGit) forceClean(repoURL string, repoPath string) {
+	gc.Info("watcherGit", "Force clean directive found in the commit message, cleaning "+repoPath)
+	err := new(gc.PipedExec).
+		Command("git", "clean", "-ffdx").
+		WorkingDir(repoPath).
+		Run(os.Stdout, os.Stderr)
+	gc.PanicIfError(err)
+	if _, err := os.Stat(path.Join(repoPath, ".gitmodules")); err == nil {
+		err = new(gc.PipedExec).
+			Command("git", "submodule", "foreach", "--recursive", "git reset --hard && git clean -ffdx").
+			WorkingDir(repoPath).
+			Run(os.Stdout, os.Stderr)
+		gc.PanicIfError(err)
+		submoduleURL, _ := parseRepoURL(repoURL)
+		err = gitCommand(submoduleURL, "submodule", "update", "--init", "--recursive", "--force").
+			WorkingDir(repoPath).
+			Run(os.Stdout, os.Stderr)
+		gc.PanicIfError(err)
+	}
+}
+
+func (w *watcherGit) Deployed(repoURLs []string) (deployedRepoPaths []string) {
+	for _, repoURL := range repoURLs {
+		repoPath, _ := getAbsRepoFolders(repoURL)
+		if _, ok := w.lastCommitHashes[repoPath]; !ok || !fileExists(repoPath) {
+			return nil
+		}
+		deployedRepoPaths = append(deployedRepoPaths, repoPath)
+	}
+	return
+}
+
+func (w *watcherGit) Revision(repoPath string) (repo string, revision string) {
+	repo = repoPath
+	for _, repoURL := range repoURLs {
+		if urlRepoPath, _ := getAbsRepoFolders(repoURL); urlRepoPath == repoPath {
+			repo = repoURL
+		}
+	}
+	return repo, w.lastCommitHashes[repoPath]
+}
+
+// Commit does nothing: commit hashes are stored by Watch(), all changed repos are deployed together
+func (w *watcherGit) Commit(repoPaths []string) {
+}
+
 func (w *watcherGit) Watch(repoURLs []string) (changedRepoPaths []string) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -46,6 +93,18 @@ func (w *watcherGit) Watch(repoURLs []string) (changedRepoPaths []string) {
 
 	// *************************************************
 	reposFolder := getReposFolder()
+	commitDeployArgs = nil
+	if tracker, ok := w.commitsTracker.(IIterationTracker); ok {
+		tracker.StartIteration()
+	}
+	// commits which are not deployed are persisted right away, so they are not evaluated again after restart
+	skipped := false
+	previousHashes := map[string]string{}
+	defer func() {
+		if skipped {
+			w.saveSkippedCommits(changedRepoPaths, previousHashes)
+		}
+	}()
 
 	for _, repoURL := range repoURLs {
 		repoPath, repoFolder := getAbsRepoFolders(repoURL)
@@ -56,11 +115,20 @@ func (w *watcherGit) Watch(repoURLs []string) (changedRepoPaths []string) {
 
 		if _, err := os.Stat(repoPath); os.IsNotExist(err) {
 			gc.Info("watcherGit", "Repo folder does not exist, will be cloned", repoPath, repoURL)
-			err := new(gc.PipedExec).
-				Command("git", "clone", "--recurse-submodules", repoURL).
+			cloneURL, ref := parseRepoURL(repoURL)
+			cloneArgs := []string{"clone", "--recurse-submodules"}
+			if rev := ref.branch + ref.tag; len(rev) > 0 {
+				cloneArgs = append(cloneArgs, "--branch", rev)
+			}
+			cloneArgs = append(cloneArgs, cloneURL, repoPath)
+			gc.PanicIfError(os.MkdirAll(path.Dir(repoPath), 0755))
+			err := gitCommand(cloneURL, cloneArgs...).
 				WorkingDir(reposFolder).
 				Run(os.Stdout, os.Stderr)
 			gc.PanicIfError(err)
+			if len(ref.commit) > 0 {
+				gc.PanicIfError(checkoutCommit(cloneURL, repoPath, ref.commit))
+			}
 		}
 
 		newHash, ok := w.commitsTracker.GetLastCommit(repoURL, repoPath)
@@ -70,6 +138,26 @@ func (w *watcherGit) Watch(repoURLs []string) (changedRepoPaths []string) {
 				continue
 			}
 			gc.Info("watcherGit", "Commit hash changed", repoURL, oldHash, newHash)
+			directives := readCommitDirectives(repoPath, oldHash, newHash)
+			if directives.skip {
+				gc.Info("watcherGit", "Skip directive found in the commit message, deploy skipped", repoURL, newHash)
+				w.lastCommitHashes[repoPath] = newHash
+				skipped = true
+				continue
+			}
+			if filter := getPathFilter(repoURL); filter != nil && len(oldHash) > 0 && !filter.changed(repoPath, oldHash, newHash) {
+				gc.Info("watcherGit", "No matching files changed, deploy skipped", repoURL)
+				w.lastCommitHashes[repoPath] = newHash
+				skipped = true
+				continue
+			}
+			if directives.forceClean {
+				w.forceClean(repoURL, repoPath)
+			}
+			if len(directives.args) > 0 {
+				gc.Info("watcherGit", "Deploy arguments from the commit message", directives.args)
+				commitDeployArgs = append(commitDeployArgs, directives.args...)
+			}
 		} else if _, ok := w.lastCommitHashes[repoPath]; ok {
 			// built once already -> skip
 			continue
@@ -77,15 +165,35 @@ func (w *watcherGit) Watch(repoURLs []string) (changedRepoPaths []string) {
 		gitModulesPath := path.Join(repoPath, ".gitmodules")
 		if _, err := os.Stat(gitModulesPath); err == nil {
 			gc.Doing("watcherGit: updating modules")
-			err = new(gc.PipedExec).
-				Command("git", "submodule", "update", "--init", "--recursive").
+			submoduleURL, _ := parseRepoURL(repoURL)
+			err = gitCommand(submoduleURL, "submodule", "update", "--init", "--recursive").
 				WorkingDir(repoPath).
 				Run(os.Stdout, os.Stderr)
 			gc.PanicIfError(err)
 		}
+		if oldHash, ok := w.lastCommitHashes[repoPath]; ok {
+			previousHashes[repoPath] = oldHash
+		}
 		w.lastCommitHashes[repoPath] = newHash
 		changedRepoPaths = append(changedRepoPaths, repoPath)
 	}
 
 	return
 }
+
+// saveSkippedCommits saves the state with skipped commits. Changed repos are not deployed yet, so their previous commits are saved
+func (w *watcherGit) saveSkippedCommits(changedRepoPaths []string, previousHashes map[string]string) {
+	newHashes := map[string]string{}
+	for _, repoPath := range changedRepoPaths {
+		newHashes[repoPath] = w.lastCommitHashes[repoPath]
+		if previousHash, ok := previousHashes[repoPath]; ok {
+			w.lastCommitHashes[repoPath] = previousHash
+		} else {
+			delete(w.lastCommitHashes, repoPath)
+		}
+	}
+	state.save()
+	for repoPath, newHash := range newHashes {
+		w.lastCommitHashes[repoPath] = newHash
+	}
+}
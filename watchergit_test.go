@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	gc "github.com/untillpro/gochips"
+)
+
+// gitTrackerPullStub pulls the repo and returns its head commit
+type gitTrackerPullStub struct{}
+
+func (t *gitTrackerPullStub) GetLastCommit(repoURL string, repoPath string) (string, bool) {
+	gc.PanicIfError(new(gc.PipedExec).Command("git", "-C", repoPath, "pull", "-q").Run(os.Stdout, os.Stderr))
+	return getHeadCommit(repoPath), true
+}
+
+func TestWatcherGitSavesSkippedCommits(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "cder-watchergit")
+	require.Nil(t, err)
+	defer os.RemoveAll(tempDir)
+	defer func(wd string, s *cderState) { workingDir, state = wd, s }(workingDir, state)
+	workingDir = path.Join(tempDir, "wd")
+	state = newCderState()
+
+	var repoURLs []string
+	var firstCommits []string
+	for _, name := range []string{"changed", "skipped"} {
+		originDir := path.Join(tempDir, name)
+		initTestRepo(t, originDir)
+		firstCommits = append(firstCommits, commitTestRepo(t, originDir, "initial"))
+		repoURLs = append(repoURLs, "file://"+originDir)
+	}
+	changedPath, _ := getAbsRepoFolders(repoURLs[0])
+	skippedPath, _ := getAbsRepoFolders(repoURLs[1])
+
+	w := &watcherGit{lastCommitHashes: state.Commits, commitsTracker: &gitTrackerPullStub{}}
+	require.Equal(t, []string{changedPath, skippedPath}, w.Watch(repoURLs))
+	state.save()
+
+	// skipped commit is saved right away, the changed one is saved after the deploy only
+	changedCommit := commitTestRepo(t, path.Join(tempDir, "changed"), "feature")
+	skippedCommit := commitTestRepo(t, path.Join(tempDir, "skipped"), "docs [skip cd]")
+	require.Equal(t, []string{changedPath}, w.Watch(repoURLs))
+	require.Equal(t, changedCommit, state.Commits[changedPath])
+	require.Equal(t, skippedCommit, state.Commits[skippedPath])
+
+	saved := newCderState()
+	stateBytes, err := ioutil.ReadFile(getStateFilePath())
+	require.Nil(t, err)
+	require.Nil(t, json.Unmarshal(stateBytes, saved))
+	require.Equal(t, firstCommits[0], saved.Commits[changedPath])
+	require.Equal(t, skippedCommit, saved.Commits[skippedPath])
+}
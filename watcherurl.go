@@ -7,103 +7,270 @@
 package main
 
 import (
-	"archive/zip"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
-	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	gc "github.com/untillpro/gochips"
 )
 
 type watcherURL struct {
-	artifactURLStored string
-	deployerURLStored string
+	// watched url -> deployed artifact and deployer urls
+	stored map[string]*urlState
+	// watched url -> state of the version prepared by watch(). Moved to `stored` after the deploy succeeds, see Commit
+	staged          map[string]*urlState
+	manifestTracker IManifestTracker
 }
 
 func (w *watcherURL) Clean(repoPathsToClean []string) {
-	// clean is not necessary because artifactWD removes each time before unzipping new artifact
+	// clean is not necessary because each version is prepared in its own dir, see pruneVersions
 }
 
+func (w *watcherURL) Deployed(repos []string) (deployedRepoPaths []string) {
+	for _, repo := range repos {
+		currentPath := getCurrentVersionPath(repo)
+		if _, ok := w.stored[repo]; ok && fileExists(path.Join(currentPath, "deploy.sh")) {
+			deployedRepoPaths = append(deployedRepoPaths, currentPath)
+		}
+	}
+	return
+}
+
+func (w *watcherURL) Revision(repoPath string) (repo string, revision string) {
+	for watchedURL, staged := range w.staged {
+		if getCurrentVersionPath(watchedURL) == repoPath {
+			return watchedURL, staged.ArtifactURL
+		}
+	}
+	for watchedURL, stored := range w.stored {
+		if getCurrentVersionPath(watchedURL) == repoPath {
+			return watchedURL, stored.ArtifactURL
+		}
+	}
+	return repoPath, ""
+}
+
+// Commit stores states of deployed urls. State of url which deploy failed stays staged,
+// so it is not saved by the deploy of another url and the url is deployed again on the next iteration
+func (w *watcherURL) Commit(repoPaths []string) {
+	for _, repoPath := range repoPaths {
+		for watchedURL, staged := range w.staged {
+			if getCurrentVersionPath(watchedURL) == repoPath {
+				w.stored[watchedURL] = staged
+				delete(w.staged, watchedURL)
+			}
+		}
+	}
+}
+
+// Watch watches each url independently, failure of one url does not prevent others from being watched
 func (w *watcherURL) Watch(repos []string) (changedRepos []string) {
-	client := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
-	}
-	repo := repos[0]
-	bodyBytes := readFromURL(client, repo)
-	if bodyBytes == nil {
+	reloadURLStates()
+	if tracker, ok := w.manifestTracker.(IIterationTracker); ok {
+		tracker.StartIteration()
+	}
+	for _, repo := range repos {
+		if changedRepo := w.watchRecovered(repo); len(changedRepo) > 0 {
+			changedRepos = append(changedRepos, changedRepo)
+		}
+	}
+	return
+}
+
+func (w *watcherURL) watchRecovered(repo string) (changedRepo string) {
+	defer func() {
+		if r := recover(); r != nil {
+			gc.Error("watcherURL: Recovered:", repo, r)
+			onError(r)
+		}
+	}()
+	return w.watch(repo)
+}
+
+// watch returns `current` path of `repo` if something is changed and should be deployed
+func (w *watcherURL) watch(repo string) (changedRepo string) {
+	client := newHTTPClient()
+	manifest, ok := w.manifestTracker.GetManifest(repo)
+	if !ok {
 		return
 	}
-	contentStr := string(bodyBytes)
-	content := strings.Split(contentStr, "\n")
-	artifactURLNew := content[0]
-	deployerURLNew := content[1]
-	_, artifactFileName := parseArtifactURL(artifactURLNew)          // artifact1
-	artifactHomePath := getArtifactHomePath(repo)                    // artifacts/<url>
-	artifactZipFile := path.Join(artifactHomePath, artifactFileName) // artifacts/<url>/artifact1.zip
-	artifactWD := path.Join(artifactHomePath, "work-dir")            // artifacts/<url>/work-dir/
+	versionID := manifest.versionID()
 
-	isChanged := false
-	deployer = &deployer4sh{
-		wd: artifactWD,
+	// copy, so the state is not changed if watching or deploy fails
+	delete(w.staged, repo)
+	stored := &urlState{}
+	// state without versions is stored by previous cder releases, redeployed
+	if prev, ok := w.stored[repo]; ok && len(prev.Version) > 0 {
+		*stored = *prev
+		stored.History = append([]*urlVersion{}, prev.History...)
+	}
+	if versionID == stored.RolledBackFrom {
+		gc.Verbose("watcherURL", "Version is rolled back, ignored till the manifest is changed", repo, versionID)
+		return
+	}
+	if len(stored.RolledBackFrom) > 0 {
+		gc.Info("watcherURL:", "manifest is changed since the rollback", repo)
+		stored.RolledBackFrom = ""
+		w.stored[repo].RolledBackFrom = ""
 	}
 
-	if artifactURLNew != w.artifactURLStored {
-		gc.Info("watcherURL:", "zip url changed", w.artifactURLStored, artifactURLNew)
-		gc.Info("watcherURL:", "cleaning artifact home dir")
-		files, err := filepath.Glob(path.Join(artifactHomePath, "*.zip"))
-		gc.PanicIfError(err)
-		for _, f := range files {
-			gc.PanicIfError(os.Remove(f))
+	isChanged := false
+	if manifest.artifactsKey() != stored.ArtifactURL || manifest.DeployerURL != stored.DeployerURL {
+		gc.Info("watcherURL:", "version changed", stored.Version, versionID)
+		var version *urlVersion
+		if kept := stored.version(versionID); kept != nil && fileExists(path.Join(getVersionPath(repo, versionID), "work-dir", "deploy.sh")) {
+			gc.Info("watcherURL:", "version is kept already", versionID)
+			keptCopy := *kept
+			version = &keptCopy
+		} else {
+			if !prepareVersion(client, repo, manifest, stored) {
+				return
+			}
+			version = &urlVersion{
+				ID:              versionID,
+				ManifestVersion: manifest.Version,
+				ArtifactURL:     manifest.artifactsKey(),
+				DeployerURL:     manifest.DeployerURL,
+			}
 		}
-		gc.PanicIfError(os.RemoveAll(artifactWD))
-		gc.PanicIfError(os.MkdirAll(artifactWD, 0755))
-		gc.Info("watcherURL:", "downloading zip...")
-		artifactZipBytes := readFromURL(client, artifactURLNew)
-		if artifactZipBytes == nil {
-			return
+		setCurrentVersion(repo, versionID)
+		version.Deployed = time.Now()
+		stored.addVersion(version)
+		stored.setVersion(version)
+		isChanged = true
+	}
+
+	if env, args := manifest.deployEnv(), manifest.Args; strings.Join(env, "\n") != strings.Join(stored.Env, "\n") || strings.Join(args, "\n") != strings.Join(stored.Args, "\n") {
+		gc.Info("watcherURL:", "deployer env or args changed", env, args)
+		version := urlVersion{ID: stored.Version, ArtifactURL: stored.ArtifactURL, DeployerURL: stored.DeployerURL}
+		if kept := stored.version(stored.Version); kept != nil {
+			version = *kept
 		}
-		gc.Info("watcherURL:", "saving zip...")
-		gc.PanicIfError(ioutil.WriteFile(artifactZipFile, artifactZipBytes, 0755))
-		unzipAll(artifactZipFile, artifactWD)
+		version.Env = env
+		version.Args = args
+		stored.addVersion(&version)
+		stored.setVersion(&version)
 		isChanged = true
-		w.artifactURLStored = artifactURLNew
-		w.deployerURLStored = ""
 	}
 
-	if deployerURLNew != w.deployerURLStored {
-		gc.Info("watcherURL:", "deployer url changed", w.deployerURLStored, deployerURLNew)
+	if isChanged {
+		pruneVersions(repo, stored)
+		w.staged[repo] = stored
+		return getCurrentVersionPath(repo)
+	}
+	return
+}
+
+// prepareVersion downloads and verifies artifacts and deployer of the manifest and extracts them to
+// `artifacts/<url>/versions/<id>/work-dir`. Artifacts are taken from the current version if they are not changed.
+// The current version is not touched, returns false if something is not published
+func prepareVersion(client *http.Client, repo string, manifest *artifactManifest, stored *urlState) bool {
+	artifactHomePath := getArtifactHomePath(repo) // artifacts/<url>
+	versionPath := getVersionPath(repo, manifest.versionID())
+	currentVersionPath := getVersionPath(repo, stored.Version)
+
+	// everything is downloaded and verified first
+	reuseArtifacts := len(stored.Version) > 0 && manifest.artifactsKey() == stored.ArtifactURL
+	for i := range manifest.Artifacts {
+		reuseArtifacts = reuseArtifacts && fileExists(getArtifactFilePath(currentVersionPath, manifest, i))
+	}
+	if !reuseArtifacts {
+		for i, artifact := range manifest.Artifacts {
+			gc.Info("watcherURL:", "downloading artifact...", artifact.URL)
+			artifactFile := getDownloadFilePath(artifactHomePath, getArtifactFilePath(versionPath, manifest, i))
+			if !downloadFile(client, artifact.URL, artifactFile, artifact.Size) {
+				return false
+			}
+			verifyDownload(client, artifact.URL, artifactFile, artifact.SHA256, artifact.Signature, !manifest.digestVerified)
+		}
+	}
+	deployerFile := getDownloadFilePath(artifactHomePath, "deploy.sh")
+	if len(manifest.DeployerURL) > 0 {
 		gc.Info("watcherURL:", "downloading deployer...")
-		artifactDeployerBytes := readFromURL(client, deployerURLNew)
-		if artifactDeployerBytes == nil {
-			return
+		if !downloadFile(client, manifest.DeployerURL, deployerFile, 0) {
+			return false
+		}
+		verifyDownload(client, manifest.DeployerURL, deployerFile, manifest.DeployerSHA256, manifest.DeployerSignature, true)
+	}
+
+	gc.Info("watcherURL:", "preparing version", versionPath)
+	gc.PanicIfError(os.RemoveAll(versionPath))
+	gc.PanicIfError(os.MkdirAll(versionPath, 0755))
+	prepared := false
+	defer func() {
+		if !prepared {
+			os.RemoveAll(versionPath)
+		}
+	}()
+	for i := range manifest.Artifacts {
+		artifactFile := getArtifactFilePath(versionPath, manifest, i)
+		if reuseArtifacts {
+			linkOrCopyFile(getArtifactFilePath(currentVersionPath, manifest, i), artifactFile)
+		} else {
+			gc.PanicIfError(os.Rename(getDownloadFilePath(artifactHomePath, artifactFile), artifactFile))
 		}
+	}
+	artifactWD := path.Join(versionPath, "work-dir")
+	extractArtifacts(versionPath, manifest, artifactWD)
+	if len(manifest.DeployerURL) > 0 {
 		gc.Info("watcherURL:", "saving deployer...")
-		os.MkdirAll(artifactHomePath, 0755)
-		if !isChanged {
-			unzipAll(artifactZipFile, artifactWD) // will clean work-dir
+		deployerPath := path.Join(artifactWD, "deploy.sh")
+		gc.PanicIfError(os.Rename(deployerFile, deployerPath))
+		gc.PanicIfError(os.Chmod(deployerPath, 0755))
+	}
+	if !fileExists(path.Join(artifactWD, "deploy.sh")) {
+		panic("watcherURL: deploy.sh is not found in the artifact and deployer url is not specified")
+	}
+	prepared = true
+
+	// stale parts of not completed downloads and files of previous cder releases
+	files, err := ioutil.ReadDir(artifactHomePath)
+	gc.PanicIfError(err)
+	for _, f := range files {
+		if f.Name() != versionsDirName && f.Name() != currentVersionLink {
+			gc.PanicIfError(os.RemoveAll(path.Join(artifactHomePath, f.Name())))
 		}
-		gc.PanicIfError(ioutil.WriteFile(path.Join(artifactWD, "deploy.sh"), artifactDeployerBytes, 0755))
-		isChanged = true
-		w.deployerURLStored = deployerURLNew
 	}
+	return true
+}
 
-	if isChanged {
-		changedRepos = append(changedRepos, artifactWD)
+// getArtifactFilePath returns path to save i-th artifact of the manifest to: artifacts/<url>/versions/<id>/artifact1.zip.
+// Index is added if there are few artifacts: artifacts/<url>/versions/<id>/1-artifact2.zip
+func getArtifactFilePath(versionPath string, manifest *artifactManifest, i int) string {
+	artifactFileName := manifest.Artifacts[i].fileName()
+	if i > 0 {
+		artifactFileName = strconv.Itoa(i) + "-" + artifactFileName
 	}
+	return path.Join(versionPath, artifactFileName)
+}
 
-	return
+// getDownloadFilePath returns path to download `file` to: artifacts/<url>/download/artifact1.zip
+func getDownloadFilePath(artifactHomePath string, file string) string {
+	downloadDir := path.Join(artifactHomePath, downloadDirName)
+	gc.PanicIfError(os.MkdirAll(downloadDir, 0755))
+	return path.Join(downloadDir, path.Base(file))
+}
+
+// extractArtifacts recreates `dir` and extracts all artifacts of the manifest which are saved to `versionPath` to it
+func extractArtifacts(versionPath string, manifest *artifactManifest, dir string) {
+	gc.PanicIfError(os.RemoveAll(dir))
+	gc.PanicIfError(os.MkdirAll(dir, 0755))
+	for i, artifact := range manifest.Artifacts {
+		extractArtifact(getArtifactFilePath(versionPath, manifest, i), artifact.fileName(), artifact.Format, dir)
+	}
 }
 
 func readFromURL(client *http.Client, url string) []byte {
-	resp, err := client.Get(url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	gc.PanicIfError(err)
+	authorizeRequest(req)
+	resp, err := client.Do(req)
 	gc.PanicIfError(err)
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
@@ -115,40 +282,6 @@ func readFromURL(client *http.Client, url string) []byte {
 	return res
 }
 
-func unzipAll(zipFile string, dir string) {
-	gc.Info("watcherURL:", "unzipping...")
-	gc.PanicIfError(os.RemoveAll(dir))
-	gc.PanicIfError(os.MkdirAll(dir, 0755))
-
-	r, err := zip.OpenReader(zipFile)
-	gc.PanicIfError(err)
-	defer r.Close()
-
-	extractAndWriteFile := func(f *zip.File) {
-		rc, err := f.Open()
-		gc.PanicIfError(err)
-		defer rc.Close()
-
-		path := filepath.Join(dir, f.Name)
-
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(path, f.Mode())
-		} else {
-			os.MkdirAll(filepath.Dir(path), f.Mode())
-			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-			gc.PanicIfError(err)
-			defer f.Close()
-
-			_, err = io.Copy(f, rc)
-			gc.PanicIfError(err)
-		}
-	}
-
-	for _, f := range r.File {
-		extractAndWriteFile(f)
-	}
-}
-
 func getArtifactHomePath(repo string) string {
 	reg, _ := regexp.Compile("[^a-zA-Z0-9]+") // assuming errors are impossible at runtime
 	return path.Join(getArtifactsFolder(), reg.ReplaceAllString(repo, "_"))
@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2020-present unTill Pro, Ltd. and Contributors
+ *
+ * This source code is licensed under the MIT license found in the
+ * LICENSE file in the root directory of this source tree.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcherURLIndependentURLs(t *testing.T) {
+	testWD, err := ioutil.TempDir("", "cder-watcherurl")
+	require.Nil(t, err)
+	defer os.RemoveAll(testWD)
+	defer func(wd string, handler func(r interface{})) { workingDir, onError = wd, handler }(workingDir, onError)
+	workingDir = testWD
+	var failures []interface{}
+	onError = func(r interface{}) { failures = append(failures, r) }
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/deploy.sh":
+			fmt.Fprintln(w, "#!/bin/sh")
+		case "/good":
+			fmt.Fprintf(w, `{"formatVersion": 2, "artifacts": [{"url": "http://%s/deploy.sh"}]}`, r.Host)
+		case "/bad":
+			fmt.Fprintf(w, `{"formatVersion": 2, "artifacts": [{"url": "http://%s/deploy.sh", "sha256": "0000"}]}`, r.Host)
+		}
+	}))
+	defer ts.Close()
+
+	w := &watcherURL{
+		stored:          map[string]*urlState{},
+		staged:          map[string]*urlState{},
+		manifestTracker: newManifestTrackerURL(),
+	}
+	repos := []string{ts.URL + "/bad", ts.URL + "/good"}
+	changedRepos := w.Watch(repos)
+	goodWD := getCurrentVersionPath(ts.URL + "/good")
+	require.Equal(t, []string{goodWD}, changedRepos)
+	require.Len(t, failures, 1)
+	require.NotContains(t, w.stored, ts.URL+"/bad")
+
+	// state is staged till the deploy succeeds, so the url is deployed again if its deploy failed
+	require.Contains(t, w.staged, ts.URL+"/good")
+	require.NotContains(t, w.stored, ts.URL+"/good")
+	require.Empty(t, w.Deployed(repos))
+	require.Equal(t, []string{goodWD}, w.Watch(repos))
+
+	w.Commit(changedRepos)
+	require.Contains(t, w.stored, ts.URL+"/good")
+	require.Empty(t, w.staged)
+	require.Equal(t, []string{goodWD}, w.Deployed(repos))
+	require.Empty(t, w.Watch(repos))
+
+	d := newDeployer4urls(repos)
+	require.Equal(t, ts.URL+"/good", d.get(goodWD).repo)
+	require.Panics(t, func() { d.get(testWD) })
+}